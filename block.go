@@ -132,7 +132,17 @@ func (block *Block) PayFee(addr []byte, fee uint64) bool {
 
 // Returns a hash of the block
 func (block *Block) Hash() []byte {
-  return Sha256Bin(block.MarshalRlp())
+  return Hash(block.MarshalRlp())
+}
+
+// Root of the transactions, derived from the hashes of the transactions
+func TxRoot(txs []*Transaction) []byte {
+  hashes := make([]string, len(txs))
+  for i, tx := range txs {
+    hashes[i] = string(tx.Hash())
+  }
+
+  return Hash(Encode(hashes))
 }
 
 func (block *Block) MarshalRlp() []byte {
@@ -156,7 +166,7 @@ func (block *Block) MarshalRlp() []byte {
     // root state
     block.state.root,
     // Sha of tx
-    string(Sha256Bin([]byte(Encode(encTx)))),
+    string(TxRoot(block.transactions)),
     block.difficulty,
     uint64(block.time),
     block.nonce,
@@ -29,8 +29,15 @@ func NewBlockChain() *BlockChain {
 	// Set the last know difficulty (might be 0x0 as initial value, Genesis)
 	bc.TD = ethutil.BigD(ethutil.Config.Db.LastKnownTD())
 
-	// TODO get last block from the database
-	bc.LastBlock = bc.genesisBlock
+	// Continue from the last block written to the database
+	data, _ := ethutil.Config.Db.Get([]byte("LastBlock"))
+	if len(data) != 0 {
+		bc.LastBlock = ethutil.NewBlock(data)
+	} else {
+		bc.LastBlock = bc.genesisBlock
+		// Store the genesis block so it's known as parent of the next block
+		ethutil.Config.Db.Put(bc.genesisBlock.Hash(), bc.genesisBlock.RlpEncode())
+	}
 
 	return bc
 }
@@ -109,13 +116,25 @@ func (bm *BlockManager) ProcessBlock(block *ethutil.Block) error {
 
 	// Calculate the new total difficulty and sync back to the db
 	if bm.CalculateTD(block) {
-		ethutil.Config.Db.Put(block.Hash(), block.RlpEncode())
-		bm.bc.LastBlock = block
+		bm.writeBlock(block)
 	}
 
 	return nil
 }
 
+// Writes the block and its info to the db and records it, together with
+// the total difficulty, as the head of the chain to continue from on the
+// next start
+func (bm *BlockManager) writeBlock(block *ethutil.Block) {
+	bm.writeBlockInfo(block)
+
+	ethutil.Config.Db.Put(block.Hash(), block.RlpEncode())
+	ethutil.Config.Db.Put([]byte("LastBlock"), block.RlpEncode())
+	ethutil.Config.Db.Put([]byte("LastKnownTotalDifficulty"), bm.bc.TD.Bytes())
+
+	bm.bc.LastBlock = block
+}
+
 // Unexported method for writing extra non-essential block info to the db
 func (bm *BlockManager) writeBlockInfo(block *ethutil.Block) {
 	bi := ethutil.BlockInfo{Number: bm.LastBlockNumber.Add(bm.LastBlockNumber, big.NewInt(1))}
@@ -560,8 +579,9 @@ out:
 				dataItems[j] = string(bm.mem[strconv.Itoa(int(i))].Bytes())
 				j++
 			}
-			// TODO sign it?
-			tx := ethutil.NewTransaction(string(addr.Bytes()), value, dataItems)
+			// TODO sign it? The pool rejects unsigned transactions so until
+			// contracts can sign this transaction never makes it in to the pool
+			tx := NewTransaction(string(addr.Bytes()), value.Uint64(), dataItems)
 			// Add the transaction to the tx pool
 			bm.server.txPool.QueueTransaction(tx)
 		case oSUICIDE:
@@ -16,7 +16,13 @@ func NewLDBDatabase() (*LDBDatabase, error) {
   // This will eventually have to be something like a resource folder.
   // it works on my system for now. Probably won't work on Windows
   usr, _ := user.Current()
-  dbPath := path.Join(usr.HomeDir, ".ethereum", "database")
+
+  return NewLDBDatabaseAt(path.Join(usr.HomeDir, ".ethereum"))
+}
+
+// Opens (or creates) the database in the given data directory
+func NewLDBDatabaseAt(dataDir string) (*LDBDatabase, error) {
+  dbPath := path.Join(dataDir, "database")
 
   // Open the db
   db, err := leveldb.OpenFile(dbPath, nil)
@@ -46,7 +52,17 @@ func (db *LDBDatabase) Put(key []byte, value []byte) {
 }
 
 func (db *LDBDatabase) Get(key []byte) ([]byte, error) {
-  return nil, nil
+  return db.db.Get(key, nil)
+}
+
+func (db *LDBDatabase) LastKnownTD() []byte {
+  data, _ := db.db.Get([]byte("LastKnownTotalDifficulty"), nil)
+
+  if len(data) == 0 {
+    data = []byte{0x0}
+  }
+
+  return data
 }
 
 func (db *LDBDatabase) Close() {
@@ -54,3 +70,11 @@ func (db *LDBDatabase) Close() {
   db.db.Close()
 }
 
+func (db *LDBDatabase) Print() {
+  iter := db.db.NewIterator(nil)
+  for iter.Next() {
+    fmt.Printf("%x(%d): ", iter.Key(), len(iter.Key()))
+    PrintSlice(DecodeNode(iter.Value()))
+  }
+}
+
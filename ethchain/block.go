@@ -46,6 +46,8 @@ type Block struct {
 	// List of transactions and/or contracts
 	transactions []*Transaction
 	TxSha        []byte
+	// Incremental tree of the transactions, used while assembling
+	txTree *MerkleBuilder
 }
 
 // New block takes a raw encoded string
@@ -75,7 +77,7 @@ func CreateBlock(root interface{},
 	Difficulty *big.Int,
 	Nonce []byte,
 	extra string,
-	txes []*Transaction) *Block {
+	txes []*Transaction) (*Block, error) {
 
 	block := &Block{
 		// Slice of transactions to include in this block
@@ -94,10 +96,12 @@ func CreateBlock(root interface{},
 	block.state = ethutil.NewTrie(ethutil.Config.Db, root)
 
 	for _, tx := range txes {
-		block.MakeContract(tx)
+		if err := block.MakeContract(tx); err != nil {
+			return nil, err
+		}
 	}
 
-	return block
+	return block, nil
 }
 
 // Returns a hash of the block
@@ -118,6 +122,15 @@ func (block *Block) State() *ethutil.Trie {
 	return block.state
 }
 
+// Returns a shallow copy of the block of which the state is read from and
+// written to the given database
+func (block *Block) boundTo(db ethutil.Database) *Block {
+	b := *block
+	b.state = ethutil.NewTrie(db, block.state.Root)
+
+	return &b
+}
+
 func (block *Block) Transactions() []*Transaction {
 	return block.transactions
 }
@@ -128,16 +141,21 @@ func (block *Block) GetContract(addr []byte) *Contract {
 		return nil
 	}
 
+	// The contract's state lives in the same database as the block's
 	contract := &Contract{}
-	contract.RlpDecode([]byte(data))
+	contract.rlpDecode(block.state.Database(), []byte(data))
 
 	return contract
 }
-func (block *Block) UpdateContract(addr []byte, contract *Contract) {
+func (block *Block) UpdateContract(addr []byte, contract *Contract) error {
 	// Make sure the state is synced
-	contract.State().Sync()
+	if err := contract.State().Sync(); err != nil {
+		return err
+	}
 
 	block.state.Update(string(addr), string(contract.RlpEncode()))
+
+	return nil
 }
 
 func (block *Block) GetAddr(addr []byte) *Address {
@@ -184,25 +202,28 @@ func (block *Block) PayFee(addr []byte, fee *big.Int) bool {
 
 func (block *Block) BlockInfo() BlockInfo {
 	bi := BlockInfo{}
-	data, _ := ethutil.Config.Db.Get(append(block.Hash(), []byte("Info")...))
+	data, _ := ethutil.Config.Db.Get(blockInfoKey(block.Hash()))
 	bi.RlpDecode(data)
 
 	return bi
 }
 
-func (block *Block) MakeContract(tx *Transaction) {
+func (block *Block) MakeContract(tx *Transaction) error {
 	// Create contract if there's no recipient
 	if tx.IsContract() {
 		addr := tx.Hash()
 
 		value := tx.Value
-		contract := NewContract(value, []byte(""))
+		contract := newContract(block.state.Database(), value, []byte(""))
 		block.state.Update(string(addr), string(contract.RlpEncode()))
 		for i, val := range tx.Data {
 			contract.state.Update(string(ethutil.NumberToBytes(uint64(i), 32)), val)
 		}
-		block.UpdateContract(addr, contract)
+
+		return block.UpdateContract(addr, contract)
 	}
+
+	return nil
 }
 
 /////// Block Encoding
@@ -258,7 +279,34 @@ func (block *Block) SetUncles(uncles []*Block) {
 func (block *Block) SetTransactions(txs []*Transaction) {
 	block.transactions = txs
 
-	block.TxSha = ethutil.Sha3Bin(ethutil.Encode(block.rlpTxs()))
+	block.txTree = NewMerkleBuilder()
+	for _, tx := range txs {
+		block.txTree.Append(txLeaf(tx))
+	}
+	block.TxSha = block.txRoot()
+}
+
+// Appends a transaction to the block while it's being assembled. The
+// transaction root is updated incrementally rather than being recomputed
+// over the entire list. Like SetTransactions it leaves the state alone;
+// contracts have to be created by the caller (MakeContract).
+func (block *Block) AddTransaction(tx *Transaction) {
+	if block.txTree == nil || block.txTree.Len() != len(block.transactions) {
+		block.SetTransactions(block.transactions)
+	}
+
+	block.transactions = append(block.transactions, tx)
+	block.txTree.Append(txLeaf(tx))
+	block.TxSha = block.txRoot()
+}
+
+// Root of the transaction tree, the sha of the empty list if there are none
+func (block *Block) txRoot() []byte {
+	if block.txTree.Len() == 0 {
+		return EmptyShaList
+	}
+
+	return block.txTree.Root()
 }
 
 func (block *Block) Value() *ethutil.Value {
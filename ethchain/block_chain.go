@@ -33,7 +33,7 @@ func (bc *BlockChain) Genesis() *Block {
 	return bc.genesisBlock
 }
 
-func (bc *BlockChain) NewBlock(coinbase []byte, txs []*Transaction) *Block {
+func (bc *BlockChain) NewBlock(coinbase []byte, txs []*Transaction) (*Block, error) {
 	var root interface{}
 	var lastBlockTime int64
 	hash := ZeroHash256
@@ -44,7 +44,7 @@ func (bc *BlockChain) NewBlock(coinbase []byte, txs []*Transaction) *Block {
 		lastBlockTime = bc.CurrentBlock.Time
 	}
 
-	block := CreateBlock(
+	block, err := CreateBlock(
 		root,
 		hash,
 		coinbase,
@@ -52,6 +52,9 @@ func (bc *BlockChain) NewBlock(coinbase []byte, txs []*Transaction) *Block {
 		nil,
 		"",
 		txs)
+	if err != nil {
+		return nil, err
+	}
 
 	if bc.CurrentBlock != nil {
 		var mul *big.Int
@@ -69,11 +72,15 @@ func (bc *BlockChain) NewBlock(coinbase []byte, txs []*Transaction) *Block {
 		block.Difficulty = diff
 	}
 
-	return block
+	return block, nil
 }
 
 func (bc *BlockChain) HasBlock(hash []byte) bool {
-	data, _ := ethutil.Config.Db.Get(hash)
+	return bc.hasBlock(hash, ethutil.Config.Db)
+}
+
+func (bc *BlockChain) hasBlock(hash []byte, db ethutil.Database) bool {
+	data, _ := db.Get(hash)
 	return len(data) != 0
 }
 
@@ -128,56 +135,97 @@ func (bc *BlockChain) setLastBlock() {
 	bc.TD = ethutil.BigD(ethutil.Config.Db.LastKnownTD())
 }
 
-func (bc *BlockChain) SetTotalDifficulty(td *big.Int) {
-	ethutil.Config.Db.Put([]byte("LastKnownTotalDifficulty"), td.Bytes())
+func (bc *BlockChain) SetTotalDifficulty(td *big.Int) error {
+	return bc.setTotalDifficulty(td, ethutil.Config.Db)
+}
+
+func (bc *BlockChain) setTotalDifficulty(td *big.Int, db ethutil.Database) error {
+	if err := db.Put([]byte("LastKnownTotalDifficulty"), td.Bytes()); err != nil {
+		return err
+	}
 	bc.TD = td
+
+	return nil
+}
+
+// Add a block to the chain and record addition information. The block itself
+// is written last so a failing write never leaves a known but incomplete
+// block behind. If writing the block fails its information is cleared
+// again. The chain's head is only moved once all writes succeeded.
+func (bc *BlockChain) Add(block *Block) error {
+	return bc.add(block, ethutil.Config.Db)
 }
 
-// Add a block to the chain and record addition information
-func (bc *BlockChain) Add(block *Block) {
-	bc.writeBlockInfo(block)
+// Adds the block, writing to the given database
+func (bc *BlockChain) add(block *Block, db ethutil.Database) error {
+	if err := bc.writeBlockInfo(block, db); err != nil {
+		return err
+	}
+
+	if err := db.Put(block.Hash(), block.RlpEncode()); err != nil {
+		// An empty record reads the same as a missing one
+		if err := db.Put(blockInfoKey(block.Hash()), nil); err != nil {
+			log.Println("[CHAIN] Failed to clear block info:", err)
+		}
+
+		return err
+	}
 
 	// Prepare the genesis block
 	bc.CurrentBlock = block
 	bc.LastBlockHash = block.Hash()
+	bc.LastBlockNumber++
 
-	ethutil.Config.Db.Put(block.Hash(), block.RlpEncode())
+	return nil
 }
 
 func (bc *BlockChain) GetBlock(hash []byte) *Block {
-	data, _ := ethutil.Config.Db.Get(hash)
+	return bc.getBlock(hash, ethutil.Config.Db)
+}
+
+func (bc *BlockChain) getBlock(hash []byte, db ethutil.Database) *Block {
+	data, _ := db.Get(hash)
 
 	return NewBlockFromData(data)
 }
 
 func (bc *BlockChain) BlockInfoByHash(hash []byte) BlockInfo {
 	bi := BlockInfo{}
-	data, _ := ethutil.Config.Db.Get(append(hash, []byte("Info")...))
+	data, _ := ethutil.Config.Db.Get(blockInfoKey(hash))
 	bi.RlpDecode(data)
 
 	return bi
 }
 
 func (bc *BlockChain) BlockInfo(block *Block) BlockInfo {
+	return bc.blockInfo(block, ethutil.Config.Db)
+}
+
+func (bc *BlockChain) blockInfo(block *Block, db ethutil.Database) BlockInfo {
 	bi := BlockInfo{}
-	data, _ := ethutil.Config.Db.Get(append(block.Hash(), []byte("Info")...))
+	data, _ := db.Get(blockInfoKey(block.Hash()))
 	bi.RlpDecode(data)
 
 	return bi
 }
 
 // Unexported method for writing extra non-essential block info to the db
-func (bc *BlockChain) writeBlockInfo(block *Block) {
-	bc.LastBlockNumber++
-	bi := BlockInfo{Number: bc.LastBlockNumber, Hash: block.Hash(), Parent: block.PrevHash}
+func (bc *BlockChain) writeBlockInfo(block *Block, db ethutil.Database) error {
+	bi := BlockInfo{Number: bc.LastBlockNumber + 1, Hash: block.Hash(), Parent: block.PrevHash}
 
-	// For now we use the block hash with the words "info" appended as key
-	ethutil.Config.Db.Put(append(block.Hash(), []byte("Info")...), bi.RlpEncode())
+	return db.Put(blockInfoKey(block.Hash()), bi.RlpEncode())
+}
+
+// For now we use the block hash with the words "info" appended as key
+func blockInfoKey(hash []byte) []byte {
+	return append(append([]byte{}, hash...), []byte("Info")...)
 }
 
 func (bc *BlockChain) Stop() {
 	if bc.CurrentBlock != nil {
-		ethutil.Config.Db.Put([]byte("LastBlock"), bc.CurrentBlock.RlpEncode())
+		if err := ethutil.Config.Db.Put([]byte("LastBlock"), bc.CurrentBlock.RlpEncode()); err != nil {
+			log.Println("[CHAIN] Failed to write last block:", err)
+		}
 
 		log.Println("[CHAIN] Stopped")
 	}
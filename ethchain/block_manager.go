@@ -41,6 +41,9 @@ type BlockManager struct {
 	Speaker PublicSpeaker
 
 	SecondaryBlockProcessor BlockProcessor
+
+	// Keeps track of side chains and alerts when they become a threat
+	ForkMonitor *ForkMonitor
 }
 
 func AddTestNetFunds(block *Block) {
@@ -67,11 +70,18 @@ func NewBlockManager(speaker PublicSpeaker) *BlockManager {
 		Pow:     &EasyPow{},
 		Speaker: speaker,
 	}
+	bm.ForkMonitor = NewForkMonitor(bm.bc)
 
 	if bm.bc.CurrentBlock == nil {
 		AddTestNetFunds(bm.bc.genesisBlock)
+		// Persist the genesis state so later blocks can be reverted to it
+		if err := bm.bc.genesisBlock.State().Sync(); err != nil {
+			log.Println("[BMGR] Failed to sync genesis state:", err)
+		}
 		// Prepare the genesis block
-		bm.bc.Add(bm.bc.genesisBlock)
+		if err := bm.bc.Add(bm.bc.genesisBlock); err != nil {
+			log.Println("[BMGR] Failed to add genesis block:", err)
+		}
 
 		log.Printf("Genesis: %x\n", bm.bc.genesisBlock.Hash())
 		//log.Printf("root %x\n", bm.bc.genesisBlock.State().Root)
@@ -85,17 +95,23 @@ func (bm *BlockManager) BlockChain() *BlockChain {
 	return bm.bc
 }
 
-func (bm *BlockManager) ApplyTransactions(block *Block, txs []*Transaction) {
+// Applies the transactions on the given block. Only database errors are
+// returned, invalid transactions are simply ignored.
+func (bm *BlockManager) ApplyTransactions(block *Block, txs []*Transaction) error {
 	// Process each transaction/contract
 	for _, tx := range txs {
 		// If there's no recipient, it's a contract
 		if tx.IsContract() {
-			block.MakeContract(tx)
+			if err := block.MakeContract(tx); err != nil {
+				return err
+			}
 			bm.ProcessContract(tx, block)
 		} else {
 			bm.TransactionPool.ProcessTransaction(tx, block)
 		}
 	}
+
+	return nil
 }
 
 // Block processing and validating with a given (temporarily) state
@@ -103,6 +119,96 @@ func (bm *BlockManager) ProcessBlock(block *Block) error {
 	// Processing a blocks may never happen simultaneously
 	bm.mutex.Lock()
 	defer bm.mutex.Unlock()
+
+	added, err := bm.processBlock(block, ethutil.Config.Db)
+	if added {
+		bm.blockAdded(block, ethutil.Config.Db)
+	}
+
+	return err
+}
+
+// Validates and applies a contiguous batch of blocks, of which the first
+// block should build on top of the current head. All database writes are
+// buffered and committed in a single batched write once every block has
+// been applied. If any of the blocks fails the entire batch is rolled back
+// and the chain is left as it was. The added blocks are only passed on to
+// the secondary block processor once the batch has been committed.
+func (bm *BlockManager) InsertChain(blocks []*Block) (err error) {
+	bm.mutex.Lock()
+	defer bm.mutex.Unlock()
+
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	prevHash := bm.bc.LastBlockHash
+	for i, block := range blocks {
+		if bytes.Compare(block.PrevHash, prevHash) != 0 {
+			return ValidationError("Block #%d (%x) of batch doesn't build on %x", i, block.Hash()[:4], prevHash[:4])
+		}
+		prevHash = block.Hash()
+	}
+
+	// Remember the head of the chain so it can be restored
+	db := ethutil.Config.Db
+	currentBlock := bm.bc.CurrentBlock
+	lastBlockHash, lastBlockNumber, td := bm.bc.LastBlockHash, bm.bc.LastBlockNumber, bm.bc.TD
+
+	// All writes, including those of the state tries, go to the batch. The
+	// blocks are processed as copies bound to the batch so neither the
+	// current head nor the given blocks are left holding on to it.
+	batch := ethutil.NewBatch(db)
+	bm.bc.CurrentBlock = currentBlock.boundTo(batch)
+
+	// Hashes of the added blocks. Applying a block alters the state of the
+	// previous head, so the blocks are read back as they were committed.
+	added := make([][]byte, 0, len(blocks))
+	defer func() {
+		if err != nil {
+			batch.Discard()
+
+			bm.bc.CurrentBlock = currentBlock
+			bm.bc.LastBlockHash, bm.bc.LastBlockNumber, bm.bc.TD = lastBlockHash, lastBlockNumber, td
+		} else {
+			bm.bc.CurrentBlock = bm.bc.CurrentBlock.boundTo(db)
+
+			for _, hash := range added {
+				bm.blockAdded(bm.bc.getBlock(hash, db), db)
+			}
+		}
+	}()
+
+	for _, block := range blocks {
+		block = block.boundTo(batch)
+		ok, err := bm.processBlock(block, batch)
+		if err != nil {
+			return err
+		}
+
+		// Any block that didn't become the new head invalidates the rest
+		if !ok || bytes.Compare(bm.bc.LastBlockHash, block.Hash()) != 0 {
+			return ValidationError("Block (%x) of batch wasn't added to the chain", block.Hash()[:4])
+		}
+		added = append(added, block.Hash())
+	}
+
+	return batch.Write()
+}
+
+// Processes the block, reading from and writing to the given database.
+// Returns whether the block was added to the chain.
+func (bm *BlockManager) processBlock(block *Block, db ethutil.Database) (added bool, err error) {
+	// Snapshot the current state. If the block fails for whatever reason,
+	// including failing database writes after the state has been synced,
+	// the state is reverted so no partially applied block is left behind.
+	state := bm.bc.CurrentBlock.State()
+	snapshot := state.Root
+	defer func() {
+		if err != nil {
+			state.Revert(snapshot)
+		}
+	}()
 	// Defer the Undo on the Trie. If the block processing happened
 	// we don't want to undo but since undo only happens on dirty
 	// nodes this won't happen because Commit would have been called
@@ -111,8 +217,8 @@ func (bm *BlockManager) ProcessBlock(block *Block) error {
 
 	hash := block.Hash()
 
-	if bm.bc.HasBlock(hash) {
-		return nil
+	if bm.bc.hasBlock(hash, db) {
+		return false, nil
 	}
 
 	/*
@@ -121,37 +227,70 @@ func (bm *BlockManager) ProcessBlock(block *Block) error {
 		}
 	*/
 
+	// Blocks which don't build on top of the current head belong to a side
+	// chain. They can't be applied on the current state and are handed to
+	// the fork monitor instead.
+	if bm.bc.CurrentBlock != nil && bytes.Compare(block.PrevHash, bm.bc.LastBlockHash) != 0 && bm.ForkMonitor.Knows(block.PrevHash) {
+		// Only blocks with a valid proof of work are tracked so forged
+		// side chains can't raise alerts
+		if err := bm.verifyPow(block); err != nil {
+			return false, err
+		}
+
+		bm.ForkMonitor.Track(block)
+
+		return false, nil
+	}
+
 	// Check if we have the parent hash, if it isn't known we discard it
 	// Reasons might be catching up or simply an invalid block
-	if !bm.bc.HasBlock(block.PrevHash) && bm.bc.CurrentBlock != nil {
-		return ParentError(block.PrevHash)
+	if !bm.bc.hasBlock(block.PrevHash, db) && bm.bc.CurrentBlock != nil {
+		return false, ParentError(block.PrevHash)
 	}
 
 	// Process the transactions on to current block
-	bm.ApplyTransactions(bm.bc.CurrentBlock, block.Transactions())
+	if err := bm.ApplyTransactions(bm.bc.CurrentBlock, block.Transactions()); err != nil {
+		return false, err
+	}
 
 	// Block validation
-	if err := bm.ValidateBlock(block); err != nil {
-		return err
+	if err := bm.validateBlock(block, db); err != nil {
+		return false, err
 	}
 
 	// I'm not sure, but I don't know if there should be thrown
 	// any errors at this time.
 	if err := bm.AccumelateRewards(bm.bc.CurrentBlock, block); err != nil {
-		return err
+		return false, err
 	}
 
 	if !block.State().Cmp(bm.bc.CurrentBlock.State()) {
 		//if block.State().Root != state.Root {
-		return fmt.Errorf("Invalid merkle root. Expected %x, got %x", block.State().Root, bm.bc.CurrentBlock.State().Root)
+		return false, fmt.Errorf("Invalid merkle root. Expected %x, got %x", block.State().Root, bm.bc.CurrentBlock.State().Root)
 	}
 
 	// Calculate the new total difficulty and sync back to the db
-	if bm.CalculateTD(block) {
+	if td, ok := bm.CalculateTD(block); ok {
 		// Sync the current block's state to the database
-		bm.bc.CurrentBlock.State().Sync()
-		// Add the block to the chain
-		bm.bc.Add(block)
+		if err := bm.bc.CurrentBlock.State().Sync(); err != nil {
+			return false, err
+		}
+
+		prevTD := bm.bc.TD
+		if err := bm.bc.setTotalDifficulty(td, db); err != nil {
+			return false, err
+		}
+
+		// Add the block to the chain. If this fails the total difficulty
+		// is set back to what it was.
+		if err := bm.bc.add(block, db); err != nil {
+			if err := bm.bc.setTotalDifficulty(prevTD, db); err != nil {
+				log.Println("[BMGR] Failed to restore total difficulty:", err)
+			}
+			bm.bc.TD = prevTD
+
+			return false, err
+		}
 
 		/*
 			ethutil.Config.Db.Put(block.Hash(), block.RlpEncode())
@@ -174,21 +313,27 @@ func (bm *BlockManager) ProcessBlock(block *Block) error {
 		// Broadcast the valid block back to the wire
 		//bm.Speaker.Broadcast(ethwire.MsgBlockTy, []interface{}{block.RlpValue().Value})
 
-		// If there's a block processor present, pass in the block for further
-		// processing
-		if bm.SecondaryBlockProcessor != nil {
-			bm.SecondaryBlockProcessor.ProcessBlock(block)
-		}
+		return true, nil
+	}
+
+	fmt.Println("total diff failed")
 
-		log.Printf("[BMGR] Added block #%d (%x)\n", block.BlockInfo().Number, block.Hash())
-	} else {
-		fmt.Println("total diff failed")
+	return false, nil
+}
+
+// Passes the block, once it has been committed to the database, on to the
+// secondary block processor if one is present
+func (bm *BlockManager) blockAdded(block *Block, db ethutil.Database) {
+	if bm.SecondaryBlockProcessor != nil {
+		bm.SecondaryBlockProcessor.ProcessBlock(block)
 	}
 
-	return nil
+	log.Printf("[BMGR] Added block #%d (%x)\n", bm.bc.blockInfo(block, db).Number, block.Hash())
 }
 
-func (bm *BlockManager) CalculateTD(block *Block) bool {
+// Calculates the total difficulty of the chain with the given block on top.
+// Returns false if the new total difficulty isn't greater than the current.
+func (bm *BlockManager) CalculateTD(block *Block) (*big.Int, bool) {
 	uncleDiff := new(big.Int)
 	for _, uncle := range block.Uncles {
 		uncleDiff = uncleDiff.Add(uncleDiff, uncle.Difficulty)
@@ -202,31 +347,32 @@ func (bm *BlockManager) CalculateTD(block *Block) bool {
 	// The new TD will only be accepted if the new difficulty is
 	// is greater than the previous.
 	if td.Cmp(bm.bc.TD) > 0 {
-		// Set the new total difficulty back to the block chain
-		bm.bc.SetTotalDifficulty(td)
-
 		/*
 			if ethutil.Config.Debug {
 				log.Println("[BMGR] TD(block) =", td)
 			}
 		*/
 
-		return true
+		return td, true
 	}
 
-	return false
+	return nil, false
 }
 
 // Validates the current block. Returns an error if the block was invalid,
 // an uncle or anything that isn't on the current block chain.
 // Validation validates easy over difficult (dagger takes longer time = difficult)
 func (bm *BlockManager) ValidateBlock(block *Block) error {
+	return bm.validateBlock(block, ethutil.Config.Db)
+}
+
+func (bm *BlockManager) validateBlock(block *Block, db ethutil.Database) error {
 	// TODO
 	// 2. Check if the difficulty is correct
 
 	// Check each uncle's previous hash. In order for it to be valid
 	// is if it has the same block hash as the current
-	previousBlock := bm.bc.GetBlock(block.PrevHash)
+	previousBlock := bm.bc.getBlock(block.PrevHash, db)
 	for _, uncle := range block.Uncles {
 		if bytes.Compare(uncle.PrevHash, previousBlock.PrevHash) != 0 {
 			return ValidationError("Mismatch uncle's previous hash. Expected %x, got %x", previousBlock.PrevHash, uncle.PrevHash)
@@ -243,7 +389,11 @@ func (bm *BlockManager) ValidateBlock(block *Block) error {
 		return ValidationError("Block is too far in the future of last block (> 15 minutes)")
 	}
 
-	// Verify the nonce of the block. Return an error if it's not valid
+	return bm.verifyPow(block)
+}
+
+// Verify the nonce of the block. Return an error if it's not valid
+func (bm *BlockManager) verifyPow(block *Block) error {
 	if !bm.Pow.Verify(block.HashNoNonce(), block.Difficulty, block.Nonce) {
 		return ValidationError("Block's nonce is invalid (= %v)", block.Nonce)
 	}
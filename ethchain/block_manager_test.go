@@ -1,5 +1,15 @@
 package ethchain
 
+import (
+	"bytes"
+	"errors"
+	"github.com/ethereum/eth-go/ethdb"
+	"github.com/ethereum/eth-go/ethutil"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
 /*
 import (
 	_ "fmt"
@@ -73,3 +83,298 @@ func TestVm(t *testing.T) {
 	bm.ProcessBlock(block)
 }
 */
+
+// Memory database of which the writes can be made to fail
+type failingDatabase struct {
+	*ethdb.MemDatabase
+
+	// Writes to this key fail. If no key is set all writes fail
+	failKey []byte
+	fail    bool
+}
+
+func (db *failingDatabase) Put(key []byte, value []byte) error {
+	if db.fail && (db.failKey == nil || bytes.Compare(db.failKey, key) == 0) {
+		return errors.New("disk full")
+	}
+
+	return db.MemDatabase.Put(key, value)
+}
+
+type testPow struct{}
+
+func (pow *testPow) Search(block *Block) []byte { return nil }
+func (pow *testPow) Verify(hash []byte, diff *big.Int, nonce []byte) bool {
+	return true
+}
+
+// Database which writes batches in a single (failing) operation
+type batchDatabase struct {
+	*ethdb.MemDatabase
+
+	fail   bool
+	writes int
+}
+
+func (db *batchDatabase) WriteBatch(keys [][]byte, values [][]byte) error {
+	if db.fail {
+		return errors.New("disk full")
+	}
+
+	db.writes++
+	for i, key := range keys {
+		db.MemDatabase.Put(key, values[i])
+	}
+
+	return nil
+}
+
+// Block processor recording the blocks it's passed and the amount of batch
+// writes at that time
+type testProcessor struct {
+	db     *batchDatabase
+	blocks [][]byte
+	writes []int
+}
+
+func (p *testProcessor) ProcessBlock(block *Block) {
+	p.blocks = append(p.blocks, block.Hash())
+	p.writes = append(p.writes, p.db.writes)
+}
+
+func setupDb(db ethutil.Database) *BlockManager {
+	ethutil.ReadConfig(".ethtest")
+	ethutil.Config.Db = db
+
+	bm := NewBlockManager(nil)
+	bm.Pow = &testPow{}
+	bm.TransactionPool = NewTxPool()
+
+	return bm
+}
+
+func setupFailingDb(t *testing.T) (*BlockManager, *failingDatabase) {
+	mem, _ := ethdb.NewMemDatabase()
+	db := &failingDatabase{MemDatabase: mem}
+
+	return setupDb(db), db
+}
+
+func testBlock(bm *BlockManager) *Block {
+	block, _ := bm.bc.NewBlock(ZeroHash160, nil)
+	bm.AccumelateRewards(block, block)
+
+	return block
+}
+
+func TestProcessBlockFailingDb(t *testing.T) {
+	for _, failBlock := range []bool{false, true} {
+		bm, db := setupFailingDb(t)
+		block := testBlock(bm)
+
+		head := bm.bc.LastBlockHash
+		root := bm.bc.CurrentBlock.State().Root
+		td := bm.bc.TD
+		number := bm.bc.LastBlockNumber
+
+		db.fail = true
+		// Either fail right away (state sync) or on the very last write (the block)
+		if failBlock {
+			db.failKey = block.Hash()
+		}
+
+		if err := bm.ProcessBlock(block); err == nil {
+			t.Fatal("expected block to be rejected")
+		}
+
+		if bm.bc.HasBlock(block.Hash()) {
+			t.Error("expected block not to be stored")
+		}
+		if failBlock && len(bm.bc.BlockInfoByHash(db.failKey).Hash) != 0 {
+			t.Error("expected the block info of the failed block to be cleared")
+		}
+		if bytes.Compare(bm.bc.LastBlockHash, head) != 0 || bm.bc.LastBlockNumber != number {
+			t.Error("expected head of the chain to be unchanged")
+		}
+		if !reflect.DeepEqual(bm.bc.CurrentBlock.State().Root, root) {
+			t.Errorf("expected state root %x, got %x", root, bm.bc.CurrentBlock.State().Root)
+		}
+		if bm.bc.TD.Cmp(td) != 0 || ethutil.BigD(db.LastKnownTD()).Cmp(td) != 0 {
+			t.Errorf("expected total difficulty %v, got %v", td, bm.bc.TD)
+		}
+
+		// Once the database recovers the very same block should apply
+		db.fail = false
+		if err := bm.ProcessBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Compare(bm.bc.LastBlockHash, block.Hash()) != 0 {
+			t.Error("expected block to be the new head")
+		}
+	}
+}
+
+func TestCreateBlockFailingDb(t *testing.T) {
+	bm, db := setupFailingDb(t)
+	db.fail = true
+
+	// Enough code for the contract's state to be written to the database
+	code := make([]string, 20)
+	for i := range code {
+		code[i] = "PUSH 1"
+	}
+	contract := NewTransaction(nil, big.NewInt(100), code)
+	if _, err := bm.bc.NewBlock(ZeroHash160, []*Transaction{contract}); err == nil {
+		t.Error("expected the failing contract write to be returned")
+	}
+}
+
+// Builds a chain of valid consecutive blocks on top of the current head
+func testChain(bm *BlockManager, length int) []*Block {
+	var blocks []*Block
+
+	root, prevHash := bm.bc.CurrentBlock.State().Root, bm.bc.LastBlockHash
+	for i := 0; i < length; i++ {
+		block, _ := CreateBlock(root, prevHash, ZeroHash160, ethutil.BigPow(2, 32), nil, "", nil)
+		bm.AccumelateRewards(block, block)
+		block.State().Sync()
+
+		blocks = append(blocks, block)
+		root, prevHash = block.State().Root, block.Hash()
+	}
+
+	return blocks
+}
+
+func TestInsertChain(t *testing.T) {
+	bm, _ := setupFailingDb(t)
+	number := bm.bc.LastBlockNumber
+	blocks := testChain(bm, 3)
+	// Applying a block alters the state of the previous head, and so its hash
+	var hashes [][]byte
+	for _, block := range blocks {
+		hashes = append(hashes, block.Hash())
+	}
+
+	if err := bm.InsertChain(blocks); err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Compare(bm.bc.LastBlockHash, hashes[2]) != 0 || bm.bc.LastBlockNumber != number+3 {
+		t.Error("expected last block of the batch to be the new head")
+	}
+	if info := bm.bc.CurrentBlock.BlockInfo(); info.Number != number+3 || bytes.Compare(info.Hash, hashes[2]) != 0 {
+		t.Errorf("expected the head's block info to be stored, got #%d (%x)", info.Number, info.Hash)
+	}
+	for _, hash := range hashes {
+		if !bm.bc.HasBlock(hash) {
+			t.Errorf("expected block %x to be stored", hash)
+		}
+	}
+	if ethutil.BigD(ethutil.Config.Db.LastKnownTD()).Cmp(bm.bc.TD) != 0 {
+		t.Error("expected total difficulty to be stored")
+	}
+}
+
+func TestInsertChainRollback(t *testing.T) {
+	bm, _ := setupFailingDb(t)
+	blocks := testChain(bm, 3)
+	// Strip the reward of the middle block so its merkle root won't match
+	blocks[1], _ = CreateBlock(blocks[0].State().Root, blocks[0].Hash(), ZeroHash160, ethutil.BigPow(2, 32), nil, "", nil)
+	blocks[2].PrevHash = blocks[1].Hash()
+	var hashes [][]byte
+	for _, block := range blocks {
+		hashes = append(hashes, block.Hash())
+	}
+
+	head := bm.bc.LastBlockHash
+	root := bm.bc.CurrentBlock.State().Root
+	td := bm.bc.TD
+	number := bm.bc.LastBlockNumber
+
+	if err := bm.InsertChain(blocks); err == nil {
+		t.Fatal("expected batch to be rejected")
+	}
+
+	for _, hash := range hashes {
+		if bm.bc.HasBlock(hash) {
+			t.Errorf("expected block %x not to be stored", hash)
+		}
+	}
+	if bytes.Compare(bm.bc.LastBlockHash, head) != 0 || bm.bc.LastBlockNumber != number {
+		t.Error("expected head of the chain to be unchanged")
+	}
+	if !reflect.DeepEqual(bm.bc.CurrentBlock.State().Root, root) {
+		t.Errorf("expected state root %x, got %x", root, bm.bc.CurrentBlock.State().Root)
+	}
+	if bm.bc.TD.Cmp(td) != 0 || ethutil.BigD(ethutil.Config.Db.LastKnownTD()).Cmp(td) != 0 {
+		t.Errorf("expected total difficulty %v, got %v", td, bm.bc.TD)
+	}
+
+	// The valid part of the batch can still be inserted afterwards
+	if err := bm.InsertChain(blocks[:1]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInsertChainBatchWriter(t *testing.T) {
+	for _, fail := range []bool{false, true} {
+		mem, _ := ethdb.NewMemDatabase()
+		db := &batchDatabase{MemDatabase: mem}
+		bm := setupDb(db)
+		processor := &testProcessor{db: db}
+		bm.SecondaryBlockProcessor = processor
+
+		head, number := bm.bc.LastBlockHash, bm.bc.LastBlockNumber
+		blocks := testChain(bm, 3)
+		var hashes [][]byte
+		for _, block := range blocks {
+			hashes = append(hashes, block.Hash())
+		}
+
+		db.fail = fail
+		err := bm.InsertChain(blocks)
+		if fail != (err != nil) {
+			t.Fatalf("expected failing batch write to fail the insert (fail = %v), got %v", fail, err)
+		}
+
+		if fail {
+			if bytes.Compare(bm.bc.LastBlockHash, head) != 0 || bm.bc.LastBlockNumber != number {
+				t.Error("expected head of the chain to be unchanged")
+			}
+			for _, block := range blocks {
+				if bm.bc.HasBlock(block.Hash()) {
+					t.Errorf("expected block %x not to be stored", block.Hash())
+				}
+			}
+			if len(processor.blocks) != 0 {
+				t.Error("expected blocks of a failed batch not to be processed")
+			}
+		} else {
+			if db.writes != 1 {
+				t.Errorf("expected the batch to be written at once, got %d writes", db.writes)
+			}
+			if bytes.Compare(bm.bc.LastBlockHash, blocks[2].Hash()) != 0 || !bm.bc.HasBlock(blocks[2].Hash()) {
+				t.Error("expected last block of the batch to be the new head")
+			}
+			if len(processor.blocks) != len(blocks) {
+				t.Fatalf("expected %d processed blocks, got %d", len(blocks), len(processor.blocks))
+			}
+			for i, hash := range hashes {
+				if bytes.Compare(processor.blocks[i], hash) != 0 || processor.writes[i] != 1 {
+					t.Errorf("expected block %x to be processed in order after the batch was written", hash)
+				}
+			}
+		}
+
+		// Nothing is left bound to the batch
+		if ethutil.Config.Db != db || bm.bc.CurrentBlock.State().Database() != db {
+			t.Error("expected the head to use the database")
+		}
+		for _, block := range blocks {
+			if block.State().Database() != db {
+				t.Error("expected the given blocks to be left untouched")
+			}
+		}
+	}
+}
@@ -12,8 +12,13 @@ type Contract struct {
 }
 
 func NewContract(Amount *big.Int, root []byte) *Contract {
+	return newContract(ethutil.Config.Db, Amount, root)
+}
+
+// Creates a contract of which the state is stored in the given database
+func newContract(db ethutil.Database, Amount *big.Int, root []byte) *Contract {
 	contract := &Contract{Amount: Amount, Nonce: 0}
-	contract.state = ethutil.NewTrie(ethutil.Config.Db, string(root))
+	contract.state = ethutil.NewTrie(db, string(root))
 
 	return contract
 }
@@ -23,11 +28,15 @@ func (c *Contract) RlpEncode() []byte {
 }
 
 func (c *Contract) RlpDecode(data []byte) {
+	c.rlpDecode(ethutil.Config.Db, data)
+}
+
+func (c *Contract) rlpDecode(db ethutil.Database, data []byte) {
 	decoder := ethutil.NewValueFromBytes(data)
 
 	c.Amount = decoder.Get(0).BigInt()
 	c.Nonce = decoder.Get(1).Uint()
-	c.state = ethutil.NewTrie(ethutil.Config.Db, decoder.Get(2).Interface())
+	c.state = ethutil.NewTrie(db, decoder.Get(2).Interface())
 }
 
 func (c *Contract) State() *ethutil.Trie {
@@ -0,0 +1,203 @@
+package ethchain
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+const (
+	// Default amount of requests which may be in flight at once
+	DefaultDownloadConcurrency = 8
+	// Default amount of blocks requested at once
+	DefaultDownloadChunkSize = 50
+	// Default amount of times a request is retried
+	DefaultDownloadRetries = 5
+)
+
+// A block fetcher is able to retrieve a range of consecutive blocks, e.g.
+// a connected peer.
+type BlockFetcher interface {
+	FetchBlocks(number uint64, count int) ([]*Block, error)
+}
+
+type downloadChunk struct {
+	index    int
+	number   uint64
+	count    int
+	attempts int
+	// Fetchers which failed to deliver this chunk
+	failed map[int]bool
+}
+
+type downloadResult struct {
+	chunk   *downloadChunk
+	fetcher int
+	blocks  []*Block
+	err     error
+}
+
+// The download scheduler downloads a range of blocks from a set of fetchers.
+// The range is split in chunks of which a bounded number is requested at
+// once. Chunks which fail are retried with another fetcher and chunks which
+// arrive out of order are held back until the gap is filled, so blocks are
+// always delivered in order.
+type DownloadScheduler struct {
+	// Amount of requests which may be in flight at once. This also bounds
+	// the amount of chunks waiting for an earlier chunk to arrive.
+	Concurrency int
+	// Amount of blocks per request
+	ChunkSize int
+	// Amount of times a single chunk may fail before giving up
+	MaxRetries int
+
+	fetchers []BlockFetcher
+	// Called for each chunk of blocks, in order
+	deliver func(blocks []*Block) error
+}
+
+func NewDownloadScheduler(deliver func(blocks []*Block) error, fetchers ...BlockFetcher) *DownloadScheduler {
+	return &DownloadScheduler{
+		Concurrency: DefaultDownloadConcurrency,
+		ChunkSize:   DefaultDownloadChunkSize,
+		MaxRetries:  DefaultDownloadRetries,
+		fetchers:    fetchers,
+		deliver:     deliver,
+	}
+}
+
+// Downloads and delivers blocks from up to and including to
+func (d *DownloadScheduler) Sync(from, to uint64) error {
+	if len(d.fetchers) == 0 {
+		return errors.New("No fetchers to download from")
+	}
+	if to < from {
+		return nil
+	}
+
+	concurrency, chunkSize := d.Concurrency, d.ChunkSize
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+
+	var queue []*downloadChunk
+	for number := from; ; number += uint64(chunkSize) {
+		// The last chunk is found before moving past it, so ranges ending
+		// near the largest number don't wrap around
+		last := to-number < uint64(chunkSize)
+
+		count := chunkSize
+		if last {
+			count = int(to-number) + 1
+		}
+		queue = append(queue, &downloadChunk{index: len(queue), number: number, count: count, failed: make(map[int]bool)})
+
+		if last {
+			break
+		}
+	}
+	total := len(queue)
+
+	// The channel is buffered so requests which are still in flight when
+	// returning early don't block forever
+	results := make(chan *downloadResult, concurrency)
+	load := make([]int, len(d.fetchers))
+	pending := make(map[int][]*Block)
+	inFlight, next := 0, 0
+
+	for next < total {
+		// Only dispatch chunks within the window of the next chunk to
+		// deliver, otherwise a single slow chunk would let the amount of
+		// pending chunks grow unbounded
+		for inFlight < concurrency && len(queue) > 0 && queue[0].index < next+concurrency {
+			chunk := queue[0]
+			queue = queue[1:]
+
+			fetcher := d.pick(chunk, load)
+			load[fetcher]++
+			inFlight++
+
+			go func(chunk *downloadChunk, fetcher int) {
+				blocks, err := d.fetchers[fetcher].FetchBlocks(chunk.number, chunk.count)
+				results <- &downloadResult{chunk: chunk, fetcher: fetcher, blocks: blocks, err: err}
+			}(chunk, fetcher)
+		}
+
+		result := <-results
+		inFlight--
+		load[result.fetcher]--
+
+		chunk := result.chunk
+		if result.err == nil && len(result.blocks) != chunk.count {
+			result.err = fmt.Errorf("Expected %d blocks, got %d", chunk.count, len(result.blocks))
+		}
+
+		if result.err != nil {
+			chunk.attempts++
+			chunk.failed[result.fetcher] = true
+			if chunk.attempts > d.MaxRetries {
+				return fmt.Errorf("Failed to download blocks #%d-#%d: %v", chunk.number, chunk.number+uint64(chunk.count)-1, result.err)
+			}
+
+			log.Printf("[DLS] Fetching blocks #%d-#%d failed (%v), retrying\n", chunk.number, chunk.number+uint64(chunk.count)-1, result.err)
+
+			queue = insertChunk(queue, chunk)
+
+			continue
+		}
+
+		pending[chunk.index] = result.blocks
+		for blocks, ok := pending[next]; ok; blocks, ok = pending[next] {
+			delete(pending, next)
+
+			if err := d.deliver(blocks); err != nil {
+				return err
+			}
+			next++
+		}
+	}
+
+	return nil
+}
+
+// Picks the least busy fetcher which hasn't failed the chunk before. If all
+// of them did the least busy fetcher is picked.
+func (d *DownloadScheduler) pick(chunk *downloadChunk, load []int) int {
+	best := -1
+	for i := range d.fetchers {
+		if chunk.failed[i] {
+			continue
+		}
+		if best < 0 || load[i] < load[best] {
+			best = i
+		}
+	}
+
+	if best < 0 {
+		best = 0
+		for i := range d.fetchers {
+			if load[i] < load[best] {
+				best = i
+			}
+		}
+	}
+
+	return best
+}
+
+// Inserts the chunk keeping the queue ordered by index
+func insertChunk(queue []*downloadChunk, chunk *downloadChunk) []*downloadChunk {
+	i := 0
+	for i < len(queue) && queue[i].index < chunk.index {
+		i++
+	}
+
+	queue = append(queue, nil)
+	copy(queue[i+1:], queue[i:])
+	queue[i] = chunk
+
+	return queue
+}
@@ -0,0 +1,151 @@
+package ethchain
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Keeps track of the amount of requests in flight over several fetchers
+type flightCounter struct {
+	mutex         sync.Mutex
+	current, peak int
+}
+
+func (c *flightCounter) add(delta int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.current += delta
+	if c.current > c.peak {
+		c.peak = c.current
+	}
+}
+
+// Fetcher serving blocks from memory, optionally slow or failing
+type testFetcher struct {
+	blocks  []*Block
+	delay   time.Duration
+	counter *flightCounter
+	// Fail every n-th request (0 = never)
+	failEvery int
+
+	mutex    sync.Mutex
+	requests int
+}
+
+func (f *testFetcher) FetchBlocks(number uint64, count int) ([]*Block, error) {
+	f.counter.add(1)
+	defer f.counter.add(-1)
+
+	f.mutex.Lock()
+	f.requests++
+	fail := f.failEvery > 0 && f.requests%f.failEvery == 0
+	f.mutex.Unlock()
+
+	time.Sleep(f.delay)
+
+	if fail {
+		return nil, errors.New("peer timed out")
+	}
+
+	return f.blocks[number : number+uint64(count)], nil
+}
+
+// Fetcher recording the requested ranges, serving empty blocks
+type rangeFetcher struct {
+	mutex    sync.Mutex
+	requests map[uint64]int
+}
+
+func (f *rangeFetcher) FetchBlocks(number uint64, count int) ([]*Block, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.requests[number] = count
+
+	return make([]*Block, count), nil
+}
+
+func TestDownloadScheduler(t *testing.T) {
+	bm, _ := setupFailingDb(t)
+	blocks := testChain(bm, 40)
+	last := blocks[len(blocks)-1].Hash()
+
+	counter := &flightCounter{}
+	slow := &testFetcher{blocks: blocks, delay: 20 * time.Millisecond, counter: counter}
+	failing := &testFetcher{blocks: blocks, failEvery: 2, counter: counter}
+
+	scheduler := NewDownloadScheduler(bm.InsertChain, slow, failing)
+	scheduler.Concurrency = 3
+	scheduler.ChunkSize = 4
+
+	if err := scheduler.Sync(0, uint64(len(blocks)-1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Compare(bm.bc.LastBlockHash, last) != 0 {
+		t.Error("expected the downloaded chain to become the head")
+	}
+	if failing.requests < 2 {
+		t.Error("expected the failing fetcher to be used")
+	}
+	if counter.peak > scheduler.Concurrency {
+		t.Errorf("expected at most %d requests in flight, got %d", scheduler.Concurrency, counter.peak)
+	}
+}
+
+func TestDownloadSchedulerGivesUp(t *testing.T) {
+	bm, _ := setupFailingDb(t)
+	blocks := testChain(bm, 10)
+
+	failing := &testFetcher{blocks: blocks, failEvery: 1, counter: &flightCounter{}}
+
+	scheduler := NewDownloadScheduler(bm.InsertChain, failing)
+	scheduler.MaxRetries = 2
+	if err := scheduler.Sync(0, uint64(len(blocks)-1)); err == nil {
+		t.Error("expected sync to fail")
+	}
+	if failing.requests != 3 {
+		t.Errorf("expected 3 attempts, got %d", failing.requests)
+	}
+}
+
+func TestDownloadSchedulerMaxNumber(t *testing.T) {
+	const max = ^uint64(0)
+
+	fetcher := &rangeFetcher{requests: make(map[uint64]int)}
+	delivered := 0
+	scheduler := NewDownloadScheduler(func(blocks []*Block) error {
+		delivered += len(blocks)
+		return nil
+	}, fetcher)
+	scheduler.ChunkSize = 4
+
+	done := make(chan error, 1)
+	go func() { done <- scheduler.Sync(max-9, max) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected sync of the last numbers to end")
+	}
+
+	expected := map[uint64]int{max - 9: 4, max - 5: 4, max - 1: 2}
+	if len(fetcher.requests) != len(expected) {
+		t.Errorf("expected %d requests, got %v", len(expected), fetcher.requests)
+	}
+	for number, count := range expected {
+		if fetcher.requests[number] != count {
+			t.Errorf("expected %d blocks from #%d, got %d", count, number, fetcher.requests[number])
+		}
+	}
+	if delivered != 10 {
+		t.Errorf("expected 10 blocks to be delivered, got %d", delivered)
+	}
+}
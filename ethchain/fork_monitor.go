@@ -0,0 +1,221 @@
+package ethchain
+
+import (
+	"bytes"
+	"log"
+	"math/big"
+	"sync"
+)
+
+// A fork alert describes a side chain which became a serious competitor of
+// the main chain.
+type ForkAlert struct {
+	// Last block both chains have in common
+	ForkPoint  []byte
+	ForkNumber uint64
+	// Tips of both branches
+	MainTip []byte
+	SideTip []byte
+	// Amount of blocks on each branch since the fork point
+	MainLength uint64
+	SideLength uint64
+	// Difficulty accumulated on each branch since the fork point
+	MainTD *big.Int
+	SideTD *big.Int
+}
+
+type ForkAlertHandler func(alert *ForkAlert)
+
+// Default amount of blocks a side chain may fall behind the head before it's
+// forgotten
+const DefaultForkDepth = 256
+
+type sideBlock struct {
+	forkPoint []byte
+	number    uint64
+	length    uint64
+	td        *big.Int
+}
+
+// The fork monitor keeps track of blocks which don't extend the head of the
+// block chain. Each time a side chain grows it's compared against the main
+// chain and if it's considered a threat an alert is raised. Every fork point
+// is only reported once.
+type ForkMonitor struct {
+	bc *BlockChain
+
+	// Side chains of at least this length raise an alert (0 = disabled)
+	MaxLength uint64
+	// Side chains with a difficulty within this margin of the main chain's
+	// raise an alert (nil = disabled)
+	TDMargin *big.Int
+	// Handler which is called for each alert. It's called synchronously
+	// from Track, i.e. by the block manager while it's processing the block,
+	// and so mustn't process blocks itself.
+	Handler ForkAlertHandler
+	// Side blocks which are this many blocks behind the head are forgotten
+	MaxDepth uint64
+
+	mutex sync.Mutex
+	// Side blocks, keyed by hash
+	blocks map[string]*sideBlock
+	// Fork points which have already been reported and their number
+	reported map[string]uint64
+}
+
+func NewForkMonitor(bc *BlockChain) *ForkMonitor {
+	return &ForkMonitor{
+		bc:       bc,
+		MaxDepth: DefaultForkDepth,
+		blocks:   make(map[string]*sideBlock),
+		reported: make(map[string]uint64),
+	}
+}
+
+// Returns whether a block can be build upon, either on the main chain or on
+// one of the known side chains
+func (fm *ForkMonitor) Knows(hash []byte) bool {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	return fm.blocks[string(hash)] != nil || fm.bc.HasBlock(hash)
+}
+
+// Track a block which doesn't extend the current head of the chain. The
+// block should have been checked for a valid proof of work, the monitor
+// doesn't validate blocks itself. If the block raises an alert the handler
+// is called before Track returns, after the monitor has been unlocked.
+func (fm *ForkMonitor) Track(block *Block) {
+	fm.mutex.Lock()
+	alert := fm.track(block)
+	handler := fm.Handler
+	fm.mutex.Unlock()
+
+	if alert != nil && handler != nil {
+		handler(alert)
+	}
+}
+
+// Tracks the block and returns the alert it raises, if any. The monitor
+// should be locked.
+func (fm *ForkMonitor) track(block *Block) *ForkAlert {
+	fm.prune()
+
+	hash := block.Hash()
+	if fm.blocks[string(hash)] != nil {
+		return nil
+	}
+
+	side := &sideBlock{length: 1, td: blockTD(block)}
+	if parent := fm.blocks[string(block.PrevHash)]; parent != nil {
+		side.forkPoint = parent.forkPoint
+		side.number = parent.number + 1
+		side.length = parent.length + 1
+		side.td.Add(side.td, parent.td)
+	} else if fm.bc.HasBlock(block.PrevHash) {
+		side.forkPoint = block.PrevHash
+		side.number = fm.bc.BlockInfoByHash(block.PrevHash).Number + 1
+	} else {
+		return nil
+	}
+
+	if fm.tooDeep(side.number) {
+		return nil
+	}
+	fm.blocks[string(hash)] = side
+
+	if _, ok := fm.reported[string(side.forkPoint)]; ok {
+		return nil
+	}
+
+	mainLength, mainTD := fm.mainBranch(side.forkPoint)
+
+	var alert bool
+	if fm.MaxLength > 0 && side.length >= fm.MaxLength {
+		alert = true
+	}
+	if fm.TDMargin != nil && new(big.Int).Add(side.td, fm.TDMargin).Cmp(mainTD) >= 0 {
+		alert = true
+	}
+
+	if !alert {
+		return nil
+	}
+
+	fm.reported[string(side.forkPoint)] = side.number - side.length
+
+	// The alert is handed out once the monitor is unlocked, so it mustn't
+	// share anything with the tracked side blocks
+	forkAlert := &ForkAlert{
+		ForkPoint:  append([]byte{}, side.forkPoint...),
+		ForkNumber: fm.bc.BlockInfoByHash(side.forkPoint).Number,
+		MainTip:    append([]byte{}, fm.bc.LastBlockHash...),
+		SideTip:    hash,
+		MainLength: mainLength,
+		SideLength: side.length,
+		MainTD:     mainTD,
+		SideTD:     new(big.Int).Set(side.td),
+	}
+
+	log.Printf("[FORK] Side chain of %d blocks forked at #%d (%x). Main tip %x, side tip %x\n", forkAlert.SideLength, forkAlert.ForkNumber, forkAlert.ForkPoint[:4], forkAlert.MainTip[:4], forkAlert.SideTip[:4])
+
+	return forkAlert
+}
+
+// Returns whether a block of the given number is too far behind the head
+// to be tracked
+func (fm *ForkMonitor) tooDeep(number uint64) bool {
+	return fm.MaxDepth > 0 && number+fm.MaxDepth < fm.bc.LastBlockNumber
+}
+
+// Forgets the side blocks and reported fork points which are too far behind
+// the head so the monitor doesn't grow without bounds
+func (fm *ForkMonitor) prune() {
+	for hash, side := range fm.blocks {
+		if fm.tooDeep(side.number) {
+			delete(fm.blocks, hash)
+		}
+	}
+
+	for forkPoint, number := range fm.reported {
+		if fm.tooDeep(number) {
+			delete(fm.reported, forkPoint)
+		}
+	}
+}
+
+// Returns the amount of side blocks being tracked
+func (fm *ForkMonitor) Len() int {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	return len(fm.blocks)
+}
+
+// Walks the main chain back from the head to the fork point and returns the
+// length and difficulty of that branch
+func (fm *ForkMonitor) mainBranch(forkPoint []byte) (uint64, *big.Int) {
+	var length uint64
+	td := new(big.Int)
+
+	hash := fm.bc.LastBlockHash
+	for bytes.Compare(hash, forkPoint) != 0 && fm.bc.HasBlock(hash) {
+		block := fm.bc.GetBlock(hash)
+		td.Add(td, blockTD(block))
+		length++
+
+		hash = block.PrevHash
+	}
+
+	return length, td
+}
+
+// Difficulty a block adds to the total difficulty of its chain
+func blockTD(block *Block) *big.Int {
+	td := new(big.Int).Set(block.Difficulty)
+	for _, uncle := range block.Uncles {
+		td.Add(td, uncle.Difficulty)
+	}
+
+	return td
+}
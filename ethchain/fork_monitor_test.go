@@ -0,0 +1,192 @@
+package ethchain
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ethereum/eth-go/ethdb"
+	"github.com/ethereum/eth-go/ethutil"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// Pow which rejects every nonce
+type rejectPow struct{ testPow }
+
+func (pow *rejectPow) Verify(hash []byte, diff *big.Int, nonce []byte) bool {
+	return false
+}
+
+func setupForkTest(t *testing.T, mainLength int) (*BlockManager, []*Block) {
+	ethutil.ReadConfig(".ethtest")
+	db, _ := ethdb.NewMemDatabase()
+	ethutil.Config.Db = db
+
+	bm := NewBlockManager(nil)
+	bm.Pow = &testPow{}
+
+	chain := []*Block{bm.bc.Genesis()}
+	for i := 0; i < mainLength; i++ {
+		block, _ := CreateBlock(nil, bm.bc.LastBlockHash, ZeroHash160, ethutil.BigPow(2, 32), nil, fmt.Sprintf("main %d", i), nil)
+		bm.bc.Add(block)
+		chain = append(chain, block)
+	}
+
+	return bm, chain
+}
+
+func sideChain(t *testing.T, bm *BlockManager, parent *Block, length int) *Block {
+	prevHash := parent.Hash()
+
+	var block *Block
+	for i := 0; i < length; i++ {
+		block, _ = CreateBlock(nil, prevHash, ZeroHash160, ethutil.BigPow(2, 32), nil, fmt.Sprintf("side %d", i), nil)
+		if err := bm.ProcessBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		prevHash = block.Hash()
+	}
+
+	return block
+}
+
+func TestForkAlertDeepFork(t *testing.T) {
+	bm, chain := setupForkTest(t, 4)
+
+	var alerts []*ForkAlert
+	bm.ForkMonitor.MaxLength = 3
+	bm.ForkMonitor.Handler = func(alert *ForkAlert) {
+		alerts = append(alerts, alert)
+	}
+
+	tip := sideChain(t, bm, chain[1], 4)
+
+	if len(alerts) != 1 {
+		t.Fatalf("expected 1 alert, got %d", len(alerts))
+	}
+
+	alert := alerts[0]
+	if bytes.Compare(alert.ForkPoint, chain[1].Hash()) != 0 {
+		t.Errorf("expected fork point %x, got %x", chain[1].Hash(), alert.ForkPoint)
+	}
+	if bytes.Compare(alert.MainTip, chain[4].Hash()) != 0 {
+		t.Errorf("expected main tip %x, got %x", chain[4].Hash(), alert.MainTip)
+	}
+	if alert.SideLength != 3 || alert.MainLength != 3 {
+		t.Errorf("expected branch lengths 3/3, got %d/%d", alert.MainLength, alert.SideLength)
+	}
+	if bytes.Compare(alert.SideTip, tip.Hash()) == 0 {
+		t.Error("expected the alert to be raised before the side chain's last block")
+	}
+}
+
+func TestForkAlertShallowFork(t *testing.T) {
+	bm, chain := setupForkTest(t, 4)
+
+	var alerts []*ForkAlert
+	bm.ForkMonitor.MaxLength = 3
+	bm.ForkMonitor.Handler = func(alert *ForkAlert) {
+		alerts = append(alerts, alert)
+	}
+
+	sideChain(t, bm, chain[3], 2)
+
+	if len(alerts) != 0 {
+		t.Errorf("expected no alerts, got %d", len(alerts))
+	}
+
+	if bytes.Compare(bm.bc.LastBlockHash, chain[4].Hash()) != 0 {
+		t.Error("expected side chain blocks to leave the head untouched")
+	}
+}
+
+func TestForkAlertTDMargin(t *testing.T) {
+	bm, chain := setupForkTest(t, 4)
+
+	var alerts []*ForkAlert
+	bm.ForkMonitor.TDMargin = ethutil.BigPow(2, 32)
+	bm.ForkMonitor.Handler = func(alert *ForkAlert) {
+		alerts = append(alerts, alert)
+	}
+
+	// A single block against the main chain's three isn't close enough
+	sideChain(t, bm, chain[1], 1)
+	if len(alerts) != 0 {
+		t.Fatalf("expected no alerts, got %d", len(alerts))
+	}
+
+	sideChain(t, bm, chain[1], 2)
+	if len(alerts) != 1 {
+		t.Fatalf("expected 1 alert, got %d", len(alerts))
+	}
+}
+
+func TestForkAlertHandlerUnlocked(t *testing.T) {
+	bm, chain := setupForkTest(t, 4)
+
+	// The handler may use the monitor, which is locked while tracking
+	tracked := -1
+	bm.ForkMonitor.MaxLength = 1
+	bm.ForkMonitor.Handler = func(alert *ForkAlert) {
+		tracked = bm.ForkMonitor.Len()
+	}
+	block, _ := CreateBlock(nil, chain[1].Hash(), ZeroHash160, ethutil.BigPow(2, 32), nil, "side", nil)
+
+	done := make(chan struct{})
+	go func() {
+		bm.ForkMonitor.Track(block)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the handler to be called without the monitor being locked")
+	}
+	if tracked != 1 {
+		t.Errorf("expected the handler to see 1 tracked block, got %d", tracked)
+	}
+}
+
+func TestForkAlertInvalidPow(t *testing.T) {
+	bm, chain := setupForkTest(t, 4)
+
+	var alerts []*ForkAlert
+	bm.ForkMonitor.MaxLength = 1
+	bm.ForkMonitor.Handler = func(alert *ForkAlert) {
+		alerts = append(alerts, alert)
+	}
+	bm.Pow = &rejectPow{}
+
+	block, _ := CreateBlock(nil, chain[1].Hash(), ZeroHash160, ethutil.BigPow(2, 32), nil, "forged", nil)
+	if err := bm.ProcessBlock(block); !IsValidationErr(err) {
+		t.Errorf("expected a validation error, got %v", err)
+	}
+
+	if len(alerts) != 0 || bm.ForkMonitor.Len() != 0 {
+		t.Errorf("expected forged blocks not to be tracked, got %d alerts and %d blocks", len(alerts), bm.ForkMonitor.Len())
+	}
+}
+
+func TestForkMonitorPrune(t *testing.T) {
+	bm, chain := setupForkTest(t, 4)
+	bm.ForkMonitor.MaxDepth = 2
+
+	sideChain(t, bm, chain[3], 1)
+	if bm.ForkMonitor.Len() != 1 {
+		t.Fatalf("expected 1 side block, got %d", bm.ForkMonitor.Len())
+	}
+
+	// Extend the main chain until the side block falls too far behind
+	for i := 0; i < 3; i++ {
+		block, _ := CreateBlock(nil, bm.bc.LastBlockHash, ZeroHash160, ethutil.BigPow(2, 32), nil, fmt.Sprintf("next %d", i), nil)
+		bm.bc.Add(block)
+	}
+
+	// A block on a side chain too far behind is neither tracked, nor does
+	// it keep the old side block alive
+	sideChain(t, bm, chain[2], 1)
+	if bm.ForkMonitor.Len() != 0 {
+		t.Errorf("expected old side blocks to be pruned, got %d", bm.ForkMonitor.Len())
+	}
+}
@@ -0,0 +1,101 @@
+package ethchain
+
+import (
+	"github.com/ethereum/eth-go/ethutil"
+)
+
+// Merkle builder computes the root of a binary merkle tree over a growing
+// list of leaves. It only keeps the roots of the complete sub trees (at most
+// one per height) so appending a leaf costs O(log n) instead of re-hashing
+// the entire list.
+//
+// The tree is split at the largest power of two smaller than the amount of
+// leaves, i.e. an odd node is promoted as is rather than being paired with
+// itself. A single leaf is its own root.
+type MerkleBuilder struct {
+	// Roots of complete sub trees, ordered from left (highest) to right
+	nodes   [][]byte
+	heights []uint
+	count   int
+}
+
+func NewMerkleBuilder() *MerkleBuilder {
+	return &MerkleBuilder{}
+}
+
+func (m *MerkleBuilder) Append(leaf []byte) {
+	node, height := leaf, uint(0)
+
+	// Merge sub trees of equal height
+	for len(m.nodes) > 0 && m.heights[len(m.heights)-1] == height {
+		last := len(m.nodes) - 1
+		node = merkleHash(m.nodes[last], node)
+		height++
+
+		m.nodes, m.heights = m.nodes[:last], m.heights[:last]
+	}
+
+	m.nodes = append(m.nodes, node)
+	m.heights = append(m.heights, height)
+	m.count++
+}
+
+func (m *MerkleBuilder) Len() int {
+	return m.count
+}
+
+// Returns the root of all leaves appended so far, nil if there are none
+func (m *MerkleBuilder) Root() []byte {
+	if len(m.nodes) == 0 {
+		return nil
+	}
+
+	root := m.nodes[len(m.nodes)-1]
+	for i := len(m.nodes) - 2; i >= 0; i-- {
+		root = merkleHash(m.nodes[i], root)
+	}
+
+	return root
+}
+
+// Computes the merkle root of the leaves from scratch
+func MerkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		return nil
+	case 1:
+		return leaves[0]
+	}
+
+	split := 1
+	for split*2 < len(leaves) {
+		split *= 2
+	}
+
+	return merkleHash(MerkleRoot(leaves[:split]), MerkleRoot(leaves[split:]))
+}
+
+func merkleHash(left, right []byte) []byte {
+	return ethutil.Sha3Bin(append(append([]byte{}, left...), right...))
+}
+
+// Leaf of a transaction in the transaction tree. This is the transaction's
+// hash, the same hash by which the transaction is known everywhere else.
+func txLeaf(tx *Transaction) []byte {
+	return tx.Hash()
+}
+
+// Returns the root of the transaction tree. An empty list of transactions
+// has the sha of the empty list as root.
+func TxRoot(txs []*Transaction) []byte {
+	if len(txs) == 0 {
+		return EmptyShaList
+	}
+
+	leaves := make([][]byte, len(txs))
+	for i, tx := range txs {
+		leaves[i] = txLeaf(tx)
+	}
+
+	return MerkleRoot(leaves)
+}
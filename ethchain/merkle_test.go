@@ -0,0 +1,102 @@
+package ethchain
+
+import (
+	"bytes"
+	"github.com/ethereum/eth-go/ethdb"
+	"github.com/ethereum/eth-go/ethutil"
+	"math/big"
+	"testing"
+)
+
+func TestMerkleBuilder(t *testing.T) {
+	builder := NewMerkleBuilder()
+	if builder.Root() != nil {
+		t.Error("expected empty tree to have no root")
+	}
+
+	var leaves [][]byte
+	for i := 0; i < 70; i++ {
+		leaf := ethutil.Sha3Bin(ethutil.NumberToBytes(uint64(i), 64))
+		leaves = append(leaves, leaf)
+		builder.Append(leaf)
+
+		if root := MerkleRoot(leaves); bytes.Compare(builder.Root(), root) != 0 {
+			t.Errorf("root of %d leaves differs. Expected %x, got %x", len(leaves), root, builder.Root())
+		}
+	}
+}
+
+func TestBlockAddTransaction(t *testing.T) {
+	ethutil.ReadConfig(".ethtest")
+	db, _ := ethdb.NewMemDatabase()
+	ethutil.Config.Db = db
+
+	block, _ := CreateBlock(nil, ZeroHash256, ZeroHash160, ethutil.BigPow(2, 32), nil, "", nil)
+	if bytes.Compare(block.TxSha, EmptyShaList) != 0 {
+		t.Errorf("expected empty transaction root, got %x", block.TxSha)
+	}
+
+	var txs []*Transaction
+	for i := 0; i < 9; i++ {
+		tx := NewTransaction(ZeroHash160, big.NewInt(int64(i)), nil)
+		txs = append(txs, tx)
+
+		block.AddTransaction(tx)
+	}
+
+	if root := TxRoot(txs); bytes.Compare(block.TxSha, root) != 0 {
+		t.Errorf("expected transaction root %x, got %x", root, block.TxSha)
+	}
+
+	other, err := CreateBlock(nil, ZeroHash256, ZeroHash160, ethutil.BigPow(2, 32), nil, "", txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(block.TxSha, other.TxSha) != 0 {
+		t.Error("expected incremental root to match the block's from scratch root")
+	}
+}
+
+func TestTxRootHashes(t *testing.T) {
+	var txs []*Transaction
+	var hashes [][]byte
+	for i := 0; i < 5; i++ {
+		tx := NewTransaction(ZeroHash160, big.NewInt(int64(i)), nil)
+		txs = append(txs, tx)
+		hashes = append(hashes, tx.Hash())
+	}
+
+	if root := MerkleRoot(hashes); bytes.Compare(TxRoot(txs), root) != 0 {
+		t.Errorf("expected the root of the transaction hashes %x, got %x", root, TxRoot(txs))
+	}
+}
+
+func TestBlockAddTransactionState(t *testing.T) {
+	ethutil.ReadConfig(".ethtest")
+	db, _ := ethdb.NewMemDatabase()
+	ethutil.Config.Db = db
+
+	block, _ := CreateBlock(nil, ZeroHash256, ZeroHash160, ethutil.BigPow(2, 32), nil, "", nil)
+	root := ethutil.NewValue(block.State().Root)
+
+	// Adding a contract only updates the transaction list and root
+	tx := NewTransaction(nil, big.NewInt(100), []string{"PUSH 1"})
+	block.AddTransaction(tx)
+
+	if !root.Cmp(ethutil.NewValue(block.State().Root)) {
+		t.Error("expected state to be left untouched")
+	}
+	if block.GetContract(tx.Hash()) != nil {
+		t.Error("expected no contract to be created")
+	}
+	if len(block.Transactions()) != 1 || bytes.Compare(block.TxSha, TxRoot(block.Transactions())) != 0 {
+		t.Error("expected transaction to be added to the block")
+	}
+
+	if err := block.MakeContract(tx); err != nil {
+		t.Fatal(err)
+	}
+	if block.GetContract(tx.Hash()) == nil {
+		t.Error("expected contract to be created by MakeContract")
+	}
+}
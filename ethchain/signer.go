@@ -0,0 +1,136 @@
+package ethchain
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/ethereum/eth-go/ethutil"
+	"github.com/obscuren/secp256k1-go"
+)
+
+// Signature schemes. The scheme is selected through ethutil.Config.SignScheme
+// and is exchanged during the handshake so peers agree on it.
+const (
+	SchemeSecp256k1 = 0x00
+	SchemeEd25519   = 0x01
+)
+
+// A signer signs transaction hashes and retrieves the public key of the
+// signer from the signature values stored in the transaction (v, r, s).
+type Signer interface {
+	// Id of the scheme
+	Id() byte
+	// Sign the hash with the given private key
+	Sign(hash []byte, key []byte) (v byte, r, s []byte, err error)
+	// Public key of whoever signed the hash. Returns an error if the
+	// signature doesn't verify
+	PublicKey(hash []byte, v byte, r, s []byte) ([]byte, error)
+	// Address which belongs to the public key
+	Address(pubkey []byte) []byte
+}
+
+var signers = map[byte]Signer{
+	SchemeSecp256k1: &Secp256k1Signer{},
+	SchemeEd25519:   &Ed25519Signer{},
+}
+
+// Returns the signer for the given scheme id (nil if unknown)
+func SignerById(id byte) Signer {
+	return signers[id]
+}
+
+// Returns the signer selected in the config, secp256k1 by default
+func CurrentSigner() Signer {
+	if ethutil.Config == nil {
+		return signers[SchemeSecp256k1]
+	}
+
+	if signer := signers[ethutil.Config.SignScheme]; signer != nil {
+		return signer
+	}
+
+	return signers[SchemeSecp256k1]
+}
+
+// Recoverable ECDSA signatures over secp256k1. The public key is recovered
+// from the signature so only r, s and the recovery id (v) are stored.
+type Secp256k1Signer struct{}
+
+func (s *Secp256k1Signer) Id() byte { return SchemeSecp256k1 }
+
+func (s *Secp256k1Signer) Sign(hash []byte, key []byte) (byte, []byte, []byte, error) {
+	sig, err := secp256k1.Sign(hash, key)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if len(sig) != 65 {
+		return 0, nil, nil, fmt.Errorf("Invalid signature length %d", len(sig))
+	}
+
+	return sig[64] + 27, sig[:32], sig[32:64], nil
+}
+
+func (s *Secp256k1Signer) PublicKey(hash []byte, v byte, r, sv []byte) ([]byte, error) {
+	if v < 27 {
+		return nil, fmt.Errorf("Invalid recovery id %d", v)
+	}
+
+	// If we don't make a copy we will overwrite the existing underlying array
+	dst := make([]byte, len(r))
+	copy(dst, r)
+
+	sig := append(dst, sv...)
+	sig = append(sig, v-27)
+
+	pubkey, err := secp256k1.RecoverPubkey(hash, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return an error if public key isn't in full format
+	if len(pubkey) == 0 || pubkey[0] != 4 {
+		return nil, errors.New("Recovered public key isn't in full format")
+	}
+
+	return pubkey, nil
+}
+
+func (s *Secp256k1Signer) Address(pubkey []byte) []byte {
+	return ethutil.Sha3Bin(pubkey[1:])[12:]
+}
+
+// Ed25519 signatures. Ed25519 doesn't allow recovery of the public key so the
+// signature is stored in r and the public key in s.
+type Ed25519Signer struct{}
+
+func (s *Ed25519Signer) Id() byte { return SchemeEd25519 }
+
+func (s *Ed25519Signer) Sign(hash []byte, key []byte) (byte, []byte, []byte, error) {
+	var privk ed25519.PrivateKey
+	switch len(key) {
+	case ed25519.SeedSize:
+		privk = ed25519.NewKeyFromSeed(key)
+	case ed25519.PrivateKeySize:
+		privk = ed25519.PrivateKey(key)
+	default:
+		return 0, nil, nil, fmt.Errorf("Invalid ed25519 key length %d", len(key))
+	}
+
+	return 0, ed25519.Sign(privk, hash), []byte(privk.Public().(ed25519.PublicKey)), nil
+}
+
+func (s *Ed25519Signer) PublicKey(hash []byte, v byte, r, sv []byte) ([]byte, error) {
+	if len(sv) != ed25519.PublicKeySize || len(r) != ed25519.SignatureSize {
+		return nil, errors.New("Invalid ed25519 signature")
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(sv), hash, r) {
+		return nil, errors.New("Signature doesn't verify")
+	}
+
+	return sv, nil
+}
+
+func (s *Ed25519Signer) Address(pubkey []byte) []byte {
+	return ethutil.Sha3Bin(pubkey)[12:]
+}
@@ -0,0 +1,68 @@
+package ethchain
+
+import (
+	"encoding/hex"
+	"github.com/ethereum/eth-go/ethutil"
+	"math/big"
+	"testing"
+)
+
+func testSignScheme(t *testing.T, scheme byte, key []byte) {
+	ethutil.ReadConfig(".ethtest")
+	ethutil.Config.SignScheme = scheme
+	defer func() { ethutil.Config.SignScheme = SchemeSecp256k1 }()
+
+	if CurrentSigner().Id() != scheme {
+		t.Fatalf("expected signer %d, got %d", scheme, CurrentSigner().Id())
+	}
+
+	tx := NewTransaction(ZeroHash160, big.NewInt(1000), nil)
+	if err := tx.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+
+	sender := tx.Sender()
+	if len(sender) != 20 {
+		t.Fatalf("expected 20 byte sender, got %x", sender)
+	}
+	if !tx.Verify(sender) {
+		t.Fatal("expected signature to verify")
+	}
+	if tx.Verify(ZeroHash160) {
+		t.Error("expected signature not to verify for another address")
+	}
+
+	// Signatures should survive encoding
+	dec := NewTransactionFromData(tx.RlpEncode())
+	if !dec.Verify(sender) {
+		t.Error("expected decoded transaction to verify with the same sender")
+	}
+
+	// Any change to the transaction invalidates the signature
+	tx.Value = big.NewInt(1001)
+	if tx.Verify(sender) {
+		t.Error("expected altered transaction not to verify")
+	}
+}
+
+func TestSignSecp256k1(t *testing.T) {
+	key, _ := hex.DecodeString("3ecb44df2159c26e0f995712d4f39b6f6e499b40749b1cf1246c37f9516cb6a4")
+
+	testSignScheme(t, SchemeSecp256k1, key)
+}
+
+func TestSignEd25519(t *testing.T) {
+	key, _ := hex.DecodeString("3ecb44df2159c26e0f995712d4f39b6f6e499b40749b1cf1246c37f9516cb6a4")
+
+	testSignScheme(t, SchemeEd25519, key)
+}
+
+func TestSignUnknownScheme(t *testing.T) {
+	ethutil.ReadConfig(".ethtest")
+	ethutil.Config.SignScheme = 0xff
+	defer func() { ethutil.Config.SignScheme = SchemeSecp256k1 }()
+
+	if CurrentSigner().Id() != SchemeSecp256k1 {
+		t.Error("expected unknown schemes to fall back on secp256k1")
+	}
+}
@@ -1,8 +1,8 @@
 package ethchain
 
 import (
+	"bytes"
 	"github.com/ethereum/eth-go/ethutil"
-	"github.com/obscuren/secp256k1-go"
 	"math/big"
 )
 
@@ -20,15 +20,17 @@ func NewTransaction(to []byte, value *big.Int, data []string) *Transaction {
 	tx := Transaction{Recipient: to, Value: value}
 	tx.Nonce = 0
 
-	// Serialize the data
-	tx.Data = make([]string, len(data))
-	for i, val := range data {
+	// Serialize the data, skipping blank lines and comments
+	tx.Data = make([]string, 0, len(data))
+	for _, val := range data {
 		instr, err := ethutil.CompileInstr(val)
 		if err != nil {
 			//fmt.Printf("compile error:%d %v\n", i+1, err)
 		}
 
-		tx.Data[i] = instr
+		if instr != "" {
+			tx.Data = append(tx.Data, instr)
+		}
 	}
 
 	return &tx
@@ -68,48 +70,41 @@ func (tx *Transaction) IsContract() bool {
 	return len(tx.Recipient) == 0
 }
 
-func (tx *Transaction) Signature(key []byte) []byte {
-	hash := tx.Hash()
-
-	sig, _ := secp256k1.Sign(hash, key)
-
-	return sig
-}
-
 func (tx *Transaction) PublicKey() []byte {
-	hash := tx.Hash()
-
-	// If we don't make a copy we will overwrite the existing underlying array
-	dst := make([]byte, len(tx.r))
-	copy(dst, tx.r)
-
-	sig := append(dst, tx.s...)
-	sig = append(sig, tx.v-27)
-
-	pubkey, _ := secp256k1.RecoverPubkey(hash, sig)
+	pubkey, _ := CurrentSigner().PublicKey(tx.Hash(), tx.v, tx.r, tx.s)
 
 	return pubkey
 }
 
 func (tx *Transaction) Sender() []byte {
-	pubkey := tx.PublicKey()
+	signer := CurrentSigner()
 
-	// Validate the returned key.
-	// Return nil if public key isn't in full format
-	if pubkey[0] != 4 {
+	// Return nil if the signature doesn't verify
+	pubkey, err := signer.PublicKey(tx.Hash(), tx.v, tx.r, tx.s)
+	if err != nil {
 		return nil
 	}
 
-	return ethutil.Sha3Bin(pubkey[1:])[12:]
+	return signer.Address(pubkey)
 }
 
-func (tx *Transaction) Sign(privk []byte) error {
+// Verifies that the transaction has been signed by the given address under
+// the configured scheme. Recovery alone isn't enough, a tampered transaction
+// recovers to a different (valid) key.
+func (tx *Transaction) Verify(addr []byte) bool {
+	sender := tx.Sender()
+
+	return sender != nil && bytes.Compare(sender, addr) == 0
+}
 
-	sig := tx.Signature(privk)
+// Signs the transaction using the configured signature scheme
+func (tx *Transaction) Sign(privk []byte) error {
+	v, r, s, err := CurrentSigner().Sign(tx.Hash(), privk)
+	if err != nil {
+		return err
+	}
 
-	tx.r = sig[:32]
-	tx.s = sig[32:64]
-	tx.v = sig[64] + 27
+	tx.v, tx.r, tx.s = v, r, s
 
 	return nil
 }
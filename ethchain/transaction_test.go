@@ -3,7 +3,6 @@ package ethchain
 import (
 	"encoding/hex"
 	"fmt"
-	"github.com/ethereum/eth-go/ethutil"
 	"math/big"
 	"testing"
 )
@@ -52,3 +51,20 @@ func TestAddressRetrieval2(t *testing.T) {
 	fmt.Printf("hex tx key %x\n", tx.PublicKey())
 	fmt.Printf("seder %x\n", tx.Sender())
 }
+
+func TestTransactionBlankLines(t *testing.T) {
+	tx := NewTransaction(ZeroHash160, big.NewInt(0), []string{
+		"; store one",
+		"PUSH 1",
+		"",
+		"   ",
+		"POP ; discard it",
+	})
+
+	if len(tx.Data) != 2 {
+		t.Fatalf("Expected 2 instructions, got %d: %v", len(tx.Data), tx.Data)
+	}
+	if tx.Data[0] != "304" || tx.Data[1] != "49" {
+		t.Errorf("Unexpected instructions %v", tx.Data)
+	}
+}
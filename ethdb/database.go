@@ -12,7 +12,12 @@ type LDBDatabase struct {
 }
 
 func NewLDBDatabase() (*LDBDatabase, error) {
-	dbPath := path.Join(ethutil.Config.ExecPath, "database")
+	return NewLDBDatabaseAt(ethutil.Config.ExecPath)
+}
+
+// Opens (or creates) the database in the given data directory
+func NewLDBDatabaseAt(dataDir string) (*LDBDatabase, error) {
+	dbPath := path.Join(dataDir, "database")
 
 	// Open the db
 	db, err := leveldb.OpenFile(dbPath, nil)
@@ -25,11 +30,18 @@ func NewLDBDatabase() (*LDBDatabase, error) {
 	return database, nil
 }
 
-func (db *LDBDatabase) Put(key []byte, value []byte) {
-	err := db.db.Put(key, value, nil)
-	if err != nil {
-		fmt.Println("Error put", err)
+func (db *LDBDatabase) Put(key []byte, value []byte) error {
+	return db.db.Put(key, value, nil)
+}
+
+// Writes all pairs in a single atomic leveldb batch
+func (db *LDBDatabase) WriteBatch(keys [][]byte, values [][]byte) error {
+	batch := new(leveldb.Batch)
+	for i, key := range keys {
+		batch.Put(key, values[i])
 	}
+
+	return db.db.Write(batch, nil)
 }
 
 func (db *LDBDatabase) Get(key []byte) ([]byte, error) {
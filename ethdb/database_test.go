@@ -1,6 +1,45 @@
 package ethdb
 
 import (
-	_ "fmt"
-	_ "testing"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
 )
+
+func TestLDBDatabaseWriteBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ethdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewLDBDatabaseAt(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := [][]byte{[]byte("foo"), []byte("bar")}
+	values := [][]byte{[]byte("1"), []byte("2")}
+	if err := db.WriteBatch(keys, values); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// The batch must survive reopening the database
+	db, err = NewLDBDatabaseAt(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for i, key := range keys {
+		value, err := db.Get(key)
+		if err != nil {
+			t.Fatalf("expected %s to be written: %v", key, err)
+		}
+		if bytes.Compare(value, values[i]) != 0 {
+			t.Errorf("expected %s to be %s, got %s", key, values[i], value)
+		}
+	}
+}
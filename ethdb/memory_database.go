@@ -18,8 +18,10 @@ func NewMemDatabase() (*MemDatabase, error) {
 	return db, nil
 }
 
-func (db *MemDatabase) Put(key []byte, value []byte) {
+func (db *MemDatabase) Put(key []byte, value []byte) error {
 	db.db[string(key)] = value
+
+	return nil
 }
 
 func (db *MemDatabase) Get(key []byte) ([]byte, error) {
@@ -57,6 +57,15 @@ type Ethereum struct {
 
 	// Specifies the desired amount of maximum peers
 	MaxPeers int
+
+	// Encrypt outbound connections. Connections to peers without
+	// encryption support fail. Inbound connections are encrypted whenever
+	// the remote asks for it.
+	Encrypt bool
+	// Connect to peers without encryption support in plaintext when
+	// Encrypt is set. Anyone in between can force this downgrade, so leave
+	// it off unless older peers have to be reached.
+	AllowPlaintext bool
 }
 
 func New(caps Caps, usePnp bool) (*Ethereum, error) {
@@ -98,7 +107,19 @@ func New(caps Caps, usePnp bool) (*Ethereum, error) {
 }
 
 func (s *Ethereum) AddPeer(conn net.Conn) {
-	peer := NewPeer(conn, s, true)
+	data, _ := ethutil.Config.Db.Get([]byte("KeyRing"))
+	keyRing := ethutil.NewValueFromBytes(data)
+
+	// Set up an encrypted connection if the remote requests one
+	pconn, err := ethwire.AcceptHandshake(conn, keyRing.Get(0).Bytes(), keyRing.Get(2).Bytes())
+	if err != nil {
+		log.Println("Encryption handshake failed:", err)
+		conn.Close()
+
+		return
+	}
+
+	peer := NewPeer(pconn, s, true)
 
 	if peer != nil && s.peers.Len() < s.MaxPeers {
 		s.peers.PushBack(peer)
@@ -193,7 +214,10 @@ func (s *Ethereum) Broadcast(msgType ethwire.MsgType, data []interface{}) {
 
 func (s *Ethereum) BroadcastMsg(msg *ethwire.Msg) {
 	eachPeer(s.peers, func(p *Peer, e *list.Element) {
-		p.QueueMessage(msg)
+		// Peers only join the broadcast once their handshake is accepted
+		if p.VersionKnown() {
+			p.QueueMessage(msg)
+		}
 	})
 }
 
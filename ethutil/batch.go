@@ -0,0 +1,89 @@
+package ethutil
+
+// Databases which are capable of writing a set of key/value pairs in one
+// (atomic) operation
+type BatchWriter interface {
+	WriteBatch(keys [][]byte, values [][]byte) error
+}
+
+// A batch buffers all writes in memory until Write is called. Reads are
+// served from the buffer first and fall back on the underlying database
+// which makes the batch usable as a regular Database in the mean time.
+type Batch struct {
+	db Database
+
+	keys   [][]byte
+	values map[string][]byte
+}
+
+func NewBatch(db Database) *Batch {
+	return &Batch{db: db, values: make(map[string][]byte)}
+}
+
+func (batch *Batch) Put(key []byte, value []byte) error {
+	if _, ok := batch.values[string(key)]; !ok {
+		batch.keys = append(batch.keys, key)
+	}
+	batch.values[string(key)] = value
+
+	return nil
+}
+
+func (batch *Batch) Get(key []byte) ([]byte, error) {
+	if value, ok := batch.values[string(key)]; ok {
+		return value, nil
+	}
+
+	return batch.db.Get(key)
+}
+
+func (batch *Batch) LastKnownTD() []byte {
+	if td, ok := batch.values["LastKnownTotalDifficulty"]; ok && len(td) != 0 {
+		return td
+	}
+
+	return batch.db.LastKnownTD()
+}
+
+// Writes all buffered pairs to the underlying database. If the database is a
+// BatchWriter it's done in a single write.
+func (batch *Batch) Write() error {
+	values := make([][]byte, len(batch.keys))
+	for i, key := range batch.keys {
+		values[i] = batch.values[string(key)]
+	}
+
+	if writer, ok := batch.db.(BatchWriter); ok {
+		if err := writer.WriteBatch(batch.keys, values); err != nil {
+			return err
+		}
+	} else {
+		for i, key := range batch.keys {
+			if err := batch.db.Put(key, values[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	batch.Discard()
+
+	return nil
+}
+
+// Throws away all buffered writes
+func (batch *Batch) Discard() {
+	batch.keys = nil
+	batch.values = make(map[string][]byte)
+}
+
+func (batch *Batch) Len() int {
+	return len(batch.keys)
+}
+
+// Closing a batch doesn't close the underlying database
+func (batch *Batch) Close() {
+}
+
+func (batch *Batch) Print() {
+	batch.db.Print()
+}
@@ -24,6 +24,8 @@ type config struct {
 	Ver      string
 	Pubkey   []byte
 	Seed     bool
+	// Transaction signature scheme (0 = secp256k1)
+	SignScheme byte
 }
 
 var Config *config
@@ -2,7 +2,7 @@ package ethutil
 
 // Database interface
 type Database interface {
-	Put(key []byte, value []byte)
+	Put(key []byte, value []byte) error
 	Get(key []byte) ([]byte, error)
 	LastKnownTD() []byte
 	Close()
@@ -34,8 +34,21 @@ var OpCodes = map[string]string{
 	"LOAD": "54",
 }
 
+// Everything following the comment prefix is ignored by the compiler
+const CommentPrefix = ";"
+
+// Compiles a single line of code. Empty lines and lines only containing
+// a comment compile to an empty string and should be skipped.
 func CompileInstr(s string) (string, error) {
-	tokens := strings.Split(s, " ")
+	if i := strings.Index(s, CommentPrefix); i >= 0 {
+		s = s[:i]
+	}
+
+	tokens := strings.Fields(s)
+	if len(tokens) == 0 {
+		return "", nil
+	}
+
 	if OpCodes[tokens[0]] == "" {
 		return s, errors.New(fmt.Sprintf("OP not found: %s", tokens[0]))
 	}
@@ -44,6 +57,10 @@ func CompileInstr(s string) (string, error) {
 	op := new(big.Int)
 	op.SetString(code, 0)
 
+	if len(tokens) > 7 {
+		return s, errors.New(fmt.Sprintf("Too many arguments for %s: %d", tokens[0], len(tokens)-1))
+	}
+
 	args := make([]*big.Int, 6)
 	for i, val := range tokens[1:len(tokens)] {
 		num := new(big.Int)
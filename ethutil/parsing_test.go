@@ -18,6 +18,28 @@ func TestCompile(t *testing.T) {
 	}
 }
 
+func TestCompileComments(t *testing.T) {
+	for _, line := range []string{"", "   ", "\t", "; push one", "  ;; indented comment"} {
+		instr, err := CompileInstr(line)
+		if err != nil {
+			t.Errorf("Expected %q to compile, got: %v", line, err)
+		}
+		if instr != "" {
+			t.Errorf("Expected %q to compile to nothing, got: %s", line, instr)
+		}
+	}
+
+	instr, err := CompileInstr("  PUSH  1 ; push one")
+	if err != nil {
+		t.Error("Failed compiling instruction")
+	}
+
+	calc := int64(48 + 1*256)
+	if Big(instr).Int64() != calc {
+		t.Error("Expected", calc, ", got:", instr)
+	}
+}
+
 func TestValidInstr(t *testing.T) {
 	/*
 	  op, args, err := Instr("68163")
@@ -29,4 +51,7 @@ func TestValidInstr(t *testing.T) {
 }
 
 func TestInvalidInstr(t *testing.T) {
+	if _, err := CompileInstr("PUSH 1 2 3 4 5 6 7"); err == nil {
+		t.Error("Expected too many arguments to fail")
+	}
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	_ "encoding/binary"
 	"fmt"
+	"io"
 	_ "log"
 	_ "math"
 	"math/big"
@@ -161,73 +162,192 @@ var (
 	zeroRlp   = big.NewInt(0x0)
 )
 
+// Returns the encoding of the object. See EncodeTo.
 func Encode(object interface{}) []byte {
 	var buff bytes.Buffer
+	EncodeTo(&buff, object)
 
-	if object != nil {
-		switch t := object.(type) {
-		case *Value:
-			buff.Write(Encode(t.Raw()))
-		// Code dup :-/
-		case int:
-			buff.Write(Encode(big.NewInt(int64(t))))
-		case uint:
-			buff.Write(Encode(big.NewInt(int64(t))))
-		case int8:
-			buff.Write(Encode(big.NewInt(int64(t))))
-		case int16:
-			buff.Write(Encode(big.NewInt(int64(t))))
-		case int32:
-			buff.Write(Encode(big.NewInt(int64(t))))
-		case int64:
-			buff.Write(Encode(big.NewInt(t)))
-		case uint16:
-			buff.Write(Encode(big.NewInt(int64(t))))
-		case uint32:
-			buff.Write(Encode(big.NewInt(int64(t))))
-		case uint64:
-			buff.Write(Encode(big.NewInt(int64(t))))
-		case byte:
-			buff.Write(Encode(big.NewInt(int64(t))))
-		case *big.Int:
-			buff.Write(Encode(t.Bytes()))
-		case []byte:
-			if len(t) == 1 && t[0] <= 0x7f {
-				buff.Write(t)
-			} else if len(t) < 56 {
-				buff.WriteByte(byte(len(t) + 0x80))
-				buff.Write(t)
-			} else {
-				b := big.NewInt(int64(len(t)))
-				buff.WriteByte(byte(len(b.Bytes()) + 0xb7))
-				buff.Write(b.Bytes())
-				buff.Write(t)
-			}
-		case string:
-			buff.Write(Encode([]byte(t)))
-		case []interface{}:
-			// Inline function for writing the slice header
-			WriteSliceHeader := func(length int) {
-				if length < 56 {
-					buff.WriteByte(byte(length + 0xc0))
-				} else {
-					b := big.NewInt(int64(length))
-					buff.WriteByte(byte(len(b.Bytes()) + 0xf7))
-					buff.Write(b.Bytes())
-				}
-			}
+	return buff.Bytes()
+}
 
-			var b bytes.Buffer
-			for _, val := range t {
-				b.Write(Encode(val))
-			}
-			WriteSliceHeader(len(b.Bytes()))
-			buff.Write(b.Bytes())
+// Normalises the object to the type it's encoded as. Numbers are encoded as
+// big integers, big integers and strings as bytes. Lists and nil are
+// returned as is.
+func rlpItem(object interface{}) interface{} {
+	switch t := object.(type) {
+	case *Value:
+		return rlpItem(t.Raw())
+	case int:
+		return big.NewInt(int64(t)).Bytes()
+	case uint:
+		return big.NewInt(int64(t)).Bytes()
+	case int8:
+		return big.NewInt(int64(t)).Bytes()
+	case int16:
+		return big.NewInt(int64(t)).Bytes()
+	case int32:
+		return big.NewInt(int64(t)).Bytes()
+	case int64:
+		return big.NewInt(t).Bytes()
+	case uint16:
+		return big.NewInt(int64(t)).Bytes()
+	case uint32:
+		return big.NewInt(int64(t)).Bytes()
+	case uint64:
+		return big.NewInt(int64(t)).Bytes()
+	case byte:
+		return big.NewInt(int64(t)).Bytes()
+	case *big.Int:
+		return t.Bytes()
+	case string:
+		return []byte(t)
+	}
+
+	return object
+}
+
+// Returns the length of the header of a payload of the given length
+func rlpHeaderLen(length int) int {
+	if length < 56 {
+		return 1
+	}
+
+	return 1 + len(big.NewInt(int64(length)).Bytes())
+}
+
+// Returns the length of the encoding of a byte string of the given length
+// of which the first byte is given
+func rlpBytesLen(length int, first byte) int {
+	if length == 1 && first <= 0x7f {
+		return 1
+	}
+
+	return rlpHeaderLen(length) + length
+}
+
+// State of a single EncodeTo call. A list's header holds the length of its
+// payload, so the payload lengths of all lists are computed up front in a
+// single pass, in the order the lists are written. This way each list is
+// sized once no matter how deep it's nested.
+type rlpWriter struct {
+	w io.Writer
+	// Payload lengths of the lists and the next one to write
+	sizes []int
+	next  int
+	// Scratch space for headers and the sizes of small objects
+	header [9]byte
+	small  [8]int
+
+	n   int
+	err error
+}
+
+// Returns the length of the encoding of the object and records the
+// payload length of every list it contains
+func (e *rlpWriter) size(object interface{}) int {
+	// Strings are common and sized without converting them to bytes
+	if t, ok := object.(string); ok {
+		if len(t) == 0 {
+			return 1
+		}
+
+		return rlpBytesLen(len(t), t[0])
+	}
+
+	item := rlpItem(object)
+	if item == nil {
+		return 1
+	}
+
+	switch t := item.(type) {
+	case []byte:
+		if len(t) == 0 {
+			return 1
+		}
+
+		return rlpBytesLen(len(t), t[0])
+	case []interface{}:
+		i := len(e.sizes)
+		e.sizes = append(e.sizes, 0)
+
+		length := 0
+		for _, val := range t {
+			length += e.size(val)
 		}
-	} else {
+		e.sizes[i] = length
+
+		return rlpHeaderLen(length) + length
+	}
+
+	// Types which aren't encodable are left out
+	return 0
+}
+
+func (e *rlpWriter) write(b []byte) {
+	if e.err != nil {
+		return
+	}
+
+	n, err := e.w.Write(b)
+	e.n += n
+	e.err = err
+}
+
+// Writes the header of a payload of the given length. Short and long are
+// the offsets of short and long payloads.
+func (e *rlpWriter) writeHeader(length int, short, long byte) {
+	if length < 56 {
+		e.header[0] = short + byte(length)
+		e.write(e.header[:1])
+
+		return
+	}
+
+	b := big.NewInt(int64(length)).Bytes()
+	e.header[0] = long + byte(len(b))
+	copy(e.header[1:], b)
+	e.write(e.header[:1+len(b)])
+}
+
+func (e *rlpWriter) encode(object interface{}) {
+	item := rlpItem(object)
+	if item == nil {
 		// Empty list for nil
-		buff.WriteByte(0xc0)
+		e.writeHeader(0, 0xc0, 0xf7)
+
+		return
 	}
 
-	return buff.Bytes()
+	switch t := item.(type) {
+	case []byte:
+		if len(t) == 1 && t[0] <= 0x7f {
+			e.write(t)
+
+			return
+		}
+
+		e.writeHeader(len(t), 0x80, 0xb7)
+		e.write(t)
+	case []interface{}:
+		length := e.sizes[e.next]
+		e.next++
+
+		e.writeHeader(length, 0xc0, 0xf7)
+		for _, val := range t {
+			e.encode(val)
+		}
+	}
+}
+
+// Writes the encoding of the object to the writer. Numbers are encoded as
+// big integers, big integers and strings as bytes and nil as the empty
+// list. Nested lists are written as they're traversed instead of being
+// encoded in a buffer of their own first.
+func EncodeTo(w io.Writer, object interface{}) (int, error) {
+	e := &rlpWriter{w: w}
+	e.sizes = e.small[:0]
+	e.size(object)
+	e.encode(object)
+
+	return e.n, e.err
 }
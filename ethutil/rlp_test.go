@@ -2,6 +2,7 @@ package ethutil
 
 import (
 	"bytes"
+	"io/ioutil"
 	"math/big"
 	"reflect"
 	"testing"
@@ -125,3 +126,47 @@ func BenchmarkEncodeDecode(b *testing.B) {
 		Decode(bytes, 0)
 	}
 }
+
+// Lists nested depth deep, each holding a few items next to the nested list
+func deepList(depth int) interface{} {
+	var list interface{} = []interface{}{}
+	for i := 0; i < depth; i++ {
+		list = []interface{}{"dog", uint64(i), list}
+	}
+
+	return list
+}
+
+func TestEncodeDeepList(t *testing.T) {
+	list := deepList(100)
+
+	var buff bytes.Buffer
+	n, err := EncodeTo(&buff, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != buff.Len() || bytes.Compare(buff.Bytes(), Encode(list)) != 0 {
+		t.Error("Expected EncodeTo to write the same encoding as Encode")
+	}
+
+	// Decode the nesting back down to the innermost list
+	value := NewValueFromBytes(buff.Bytes())
+	for i := 99; i >= 0; i-- {
+		if value.Get(1).Uint() != uint64(i) {
+			t.Fatalf("Expected %d at depth %d, got %v", i, 99-i, value.Get(1))
+		}
+		value = value.Get(2)
+	}
+	if value.Len() != 0 {
+		t.Errorf("Expected the innermost list to be empty, got %v", value)
+	}
+}
+
+func BenchmarkEncodeDeepList(b *testing.B) {
+	list := deepList(200)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		EncodeTo(ioutil.Discard, list)
+	}
+}
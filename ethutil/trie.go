@@ -61,15 +61,19 @@ func (cache *Cache) Get(key []byte) *Value {
 	return value
 }
 
-func (cache *Cache) Commit() {
+// Writes all dirty nodes to the database. If a write fails the remaining
+// nodes are left dirty and the error is returned
+func (cache *Cache) Commit() error {
 	// Don't try to commit if it isn't dirty
 	if !cache.IsDirty {
-		return
+		return nil
 	}
 
 	for key, node := range cache.nodes {
 		if node.Dirty {
-			cache.db.Put([]byte(key), node.Value.Encode())
+			if err := cache.db.Put([]byte(key), node.Value.Encode()); err != nil {
+				return err
+			}
 			node.Dirty = false
 		}
 	}
@@ -80,6 +84,8 @@ func (cache *Cache) Commit() {
 	if len(cache.nodes) > 200 {
 		cache.nodes = make(map[string]*Node)
 	}
+
+	return nil
 }
 
 func (cache *Cache) Undo() {
@@ -99,21 +105,42 @@ func (cache *Cache) Undo() {
 // explicitly called.
 type Trie struct {
 	Root interface{}
+	// Root of the last synced state. Undo reverts back to this root
+	prevRoot interface{}
 	//db   Database
 	cache *Cache
 }
 
 func NewTrie(db Database, Root interface{}) *Trie {
-	return &Trie{cache: NewCache(db), Root: Root}
+	return &Trie{cache: NewCache(db), Root: Root, prevRoot: Root}
+}
+
+// Returns the database the trie reads from and writes to
+func (t *Trie) Database() Database {
+	return t.cache.db
 }
 
 // Save the cached value to the database.
-func (t *Trie) Sync() {
-	t.cache.Commit()
+func (t *Trie) Sync() error {
+	if err := t.cache.Commit(); err != nil {
+		return err
+	}
+	t.prevRoot = t.Root
+
+	return nil
 }
 
+// Discards any changes made since the last Sync
 func (t *Trie) Undo() {
+	t.Revert(t.prevRoot)
+}
+
+// Reverts the trie back to the given (previously taken) root. Dirty nodes
+// are thrown away and nodes which were already synced become unreachable.
+func (t *Trie) Revert(root interface{}) {
 	t.cache.Undo()
+	t.Root = root
+	t.prevRoot = root
 }
 
 /*
@@ -135,7 +162,7 @@ func (t *Trie) Get(key string) string {
 func (t *Trie) GetState(node interface{}, key []int) interface{} {
 	n := NewValue(node)
 	// Return the node if key is empty (= found)
-	if len(key) == 0 || n.IsNil() || n.Len() == 0 {
+	if len(key) == 0 || n.IsNil() || (n.Len() == 0 && n.ByteLen() == 0) {
 		return node
 	}
 
@@ -231,7 +258,7 @@ func (t *Trie) InsertState(node interface{}, key []int, value interface{}) inter
 
 	// New node
 	n := NewValue(node)
-	if node == nil || (n.Type() == reflect.String && (n.Str() == "" || n.Get(0).IsNil())) || n.Len() == 0 {
+	if node == nil || (n.Type() == reflect.String && (n.Str() == "" || n.Get(0).IsNil())) || (n.Len() == 0 && n.ByteLen() == 0) {
 		newNode := []interface{}{CompactEncode(key), value}
 
 		return t.Put(newNode)
@@ -16,8 +16,9 @@ func NewMemDatabase() (*MemDatabase, error) {
 	db := &MemDatabase{db: make(map[string][]byte)}
 	return db, nil
 }
-func (db *MemDatabase) Put(key []byte, value []byte) {
+func (db *MemDatabase) Put(key []byte, value []byte) error {
 	db.db[string(key)] = value
+	return nil
 }
 func (db *MemDatabase) Get(key []byte) ([]byte, error) {
 	return db.db[string(key)], nil
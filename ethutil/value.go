@@ -3,16 +3,33 @@ package ethutil
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"math/big"
 	"reflect"
+	"sync/atomic"
 )
 
 // Data values are returned by the rlp decoder. The data values represents
 // one item within the rlp data structure. It's responsible for all the casting
 // It always returns something valid
+//
+// Lists which are read more than once memoize the values of their elements
+// so repeatedly accessing a list while decoding doesn't allocate a new value
+// for every access. The values returned by Get may therefore be shared and
+// should be treated as read only.
 type Value struct {
-	Val  interface{}
-	kind reflect.Value
+	Val interface{}
+
+	// Memoized element values (*valueMemo) and the amount of Gets since
+	// the list was last set
+	memo atomic.Value
+	gets int32
+}
+
+type valueMemo struct {
+	// First element of the list the values were built for
+	of     *interface{}
+	values []Value
 }
 
 func (val *Value) String() string {
@@ -31,12 +48,18 @@ func (val *Value) IsNil() bool {
 	return val.Val == nil
 }
 
+// Returns the amount of elements if the value is a list, 0 otherwise
 func (val *Value) Len() int {
-	//return val.kind.Len()
 	if data, ok := val.Val.([]interface{}); ok {
 		return len(data)
-	} else if data, ok := val.Val.([]byte); ok {
-		// FIXME
+	}
+
+	return 0
+}
+
+// Returns the length of the value if it's a byte slice, 0 otherwise
+func (val *Value) ByteLen() int {
+	if data, ok := val.Val.([]byte); ok {
 		return len(data)
 	}
 
@@ -51,6 +74,9 @@ func (val *Value) Interface() interface{} {
 	return val.Val
 }
 
+// Returns the value as an unsigned 64 bit integer. Byte slices are read as
+// big endian numbers. Byte slices which don't fit in 64 bits return 0, use
+// BigInt for those.
 func (val *Value) Uint() uint64 {
 	if Val, ok := val.Val.(uint8); ok {
 		return uint64(Val)
@@ -65,7 +91,11 @@ func (val *Value) Uint() uint64 {
 	} else if Val, ok := val.Val.(uint); ok {
 		return uint64(Val)
 	} else if Val, ok := val.Val.([]byte); ok {
-		return ReadVarint(bytes.NewReader(Val))
+		if len(Val) > 8 {
+			return 0
+		}
+
+		return new(big.Int).SetBytes(Val).Uint64()
 	}
 
 	return 0
@@ -149,6 +179,10 @@ func (val *Value) Get(idx int) *Value {
 			panic("negative idx for Value Get")
 		}
 
+		if memo := val.listMemo(d); memo != nil {
+			return &memo.values[idx]
+		}
+
 		return NewValue(d[idx])
 	}
 
@@ -156,12 +190,112 @@ func (val *Value) Get(idx int) *Value {
 	return NewValue(nil)
 }
 
+// Returns the memoized values of the (non empty) list d, or nil if it isn't
+// worth to memoize them yet
+func (val *Value) listMemo(d []interface{}) *valueMemo {
+	// Val may have been replaced since the memo was built
+	memo, _ := val.memo.Load().(*valueMemo)
+	if memo != nil && memo.of == &d[0] && len(memo.values) == len(d) {
+		return memo
+	}
+
+	// Lists which are only read once aren't worth memoizing
+	if atomic.AddInt32(&val.gets, 1) == 1 {
+		return nil
+	}
+
+	memo = &valueMemo{of: &d[0], values: make([]Value, len(d))}
+	for i, v := range d {
+		memo.values[i].Val = v
+	}
+	val.memo.Store(memo)
+
+	return memo
+}
+
+var bigIntType = reflect.TypeOf((*big.Int)(nil))
+
+// Decodes the value in to the struct v points to. Each exported field of the
+// struct is set from the successive element of the value, which should
+// therefore be a slice. Nested structs are decoded from nested slices.
+func (val *Value) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Decode requires a pointer to a struct, got %T", v)
+	}
+
+	return val.decodeStruct(rv.Elem())
+}
+
+func (val *Value) decodeStruct(rv reflect.Value) error {
+	if _, ok := val.Val.([]interface{}); !ok {
+		return fmt.Errorf("Can't decode %T in to struct %v", val.Val, rv.Type())
+	}
+
+	idx := 0
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		// Skip unexported fields
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if idx >= val.Len() {
+			return fmt.Errorf("Too few elements to decode struct %v (%d)", rv.Type(), val.Len())
+		}
+
+		if err := val.Get(idx).decodeField(rv.Field(i)); err != nil {
+			return fmt.Errorf("%v.%s: %v", rv.Type(), field.Name, err)
+		}
+		idx++
+	}
+
+	return nil
+}
+
+func (val *Value) decodeField(rv reflect.Value) error {
+	switch {
+	case rv.Type() == bigIntType:
+		rv.Set(reflect.ValueOf(val.BigInt()))
+	case rv.Kind() == reflect.Uint8, rv.Kind() == reflect.Uint16, rv.Kind() == reflect.Uint32, rv.Kind() == reflect.Uint64, rv.Kind() == reflect.Uint:
+		// Uint returns 0 for numbers which don't fit in 64 bits
+		if b, ok := val.Val.([]byte); ok && len(b) > 8 {
+			return fmt.Errorf("%d byte number overflows %v", len(b), rv.Type())
+		}
+
+		num := val.Uint()
+		if rv.OverflowUint(num) {
+			return fmt.Errorf("%d overflows %v", num, rv.Type())
+		}
+		rv.SetUint(num)
+	case rv.Kind() == reflect.String:
+		rv.SetString(val.Str())
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8:
+		rv.SetBytes(val.Bytes())
+	case rv.Kind() == reflect.Struct:
+		return val.decodeStruct(rv)
+	default:
+		return fmt.Errorf("Unsupported field type %v", rv.Type())
+	}
+
+	return nil
+}
+
 func (val *Value) Cmp(o *Value) bool {
 	return reflect.DeepEqual(val.Val, o.Val)
 }
 
 func (val *Value) Encode() []byte {
-	return Encode(val.Val)
+	var buff bytes.Buffer
+	val.EncodeTo(&buff)
+
+	return buff.Bytes()
+}
+
+// Writes the RLP encoding of the value to the writer and returns the amount
+// of bytes written
+func (val *Value) EncodeTo(w io.Writer) (int, error) {
+	return EncodeTo(w, val.Val)
 }
 
 func NewValueFromBytes(data []byte) *Value {
@@ -198,13 +332,20 @@ func EmptyValue() *Value {
 
 func (val *Value) AppendList() *Value {
 	list := EmptyValue()
-	val.Val = append(val.Slice(), list)
+	val.setSlice(append(val.Slice(), list))
 
 	return list
 }
 
 func (val *Value) Append(v interface{}) *Value {
-	val.Val = append(val.Slice(), v)
+	val.setSlice(append(val.Slice(), v))
 
 	return val
 }
+
+// Lists which are still being built aren't memoized until they're read
+// repeatedly again
+func (val *Value) setSlice(slice []interface{}) {
+	val.Val = slice
+	atomic.StoreInt32(&val.gets, 0)
+}
@@ -50,3 +50,228 @@ func TestValueTypes(t *testing.T) {
 		t.Errorf("expected BigInt to return '%v', got %v", bigExp, bigInt.BigInt())
 	}
 }
+
+func BenchmarkValueSliceAccess(b *testing.B) {
+	list := make([]interface{}, 5000)
+	for i := range list {
+		list[i] = []interface{}{uint64(i), []byte("value")}
+	}
+	value := NewValueFromBytes(Encode(list))
+
+	items := make([]*Value, value.Len())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < value.Len(); j++ {
+			items[j] = value.Get(j).Get(1)
+		}
+	}
+}
+
+// Decodes a large list and reads every element once
+func BenchmarkValueDecodeList(b *testing.B) {
+	list := make([]interface{}, 5000)
+	for i := range list {
+		list[i] = []interface{}{uint64(i), []byte("value")}
+	}
+	data := Encode(list)
+
+	items := make([]*Value, len(list))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		value := NewValueFromBytes(data)
+		for j := 0; j < value.Len(); j++ {
+			items[j] = value.Get(j).Get(1)
+		}
+	}
+}
+
+func TestValueMemo(t *testing.T) {
+	// Literals work without being created through NewValue
+	value := &Value{Val: []interface{}{"a", "b"}}
+	for i := 0; i < 3; i++ {
+		if value.Get(1).Str() != "b" {
+			t.Fatalf("expected 'b', got %v", value.Get(1))
+		}
+	}
+	if value.Get(0) != value.Get(0) {
+		t.Error("expected repeated Gets to be memoized")
+	}
+
+	// Setters invalidate the memo
+	value.Append("c")
+	if value.Len() != 3 || value.Get(2).Str() != "c" || value.Get(0).Str() != "a" {
+		t.Errorf("expected appended element, got %v", value)
+	}
+	value.AppendList().Append(1)
+	exp := Encode([]interface{}{"a", "b", "c", []interface{}{1}})
+	if value.Len() != 4 || bytes.Compare(value.Encode(), exp) != 0 {
+		t.Errorf("expected appended list, got %v", value)
+	}
+
+	// As does replacing the list
+	value.Val = []interface{}{"d"}
+	if value.Len() != 1 || value.Get(0).Str() != "d" || !value.Get(1).IsNil() {
+		t.Errorf("expected replaced list, got %v", value)
+	}
+}
+
+type testDecodeHeader struct {
+	Number uint64
+	Hash   []byte
+}
+
+type testDecodeTx struct {
+	Nonce     uint32
+	Recipient []byte
+	Value     *big.Int
+	Memo      string
+	Header    testDecodeHeader
+	ignored   int
+	Flag      byte
+}
+
+func TestValueDecode(t *testing.T) {
+	value := NewValue([]interface{}{
+		uint32(1),
+		[]byte("recipient"),
+		[]byte{0x01, 0x00},
+		"memo",
+		[]interface{}{uint64(42), []byte{0xab}},
+		uint8(7),
+	})
+
+	var tx testDecodeTx
+	if err := value.Decode(&tx); err != nil {
+		t.Fatal(err)
+	}
+
+	if tx.Nonce != 1 {
+		t.Errorf("expected nonce 1, got %d", tx.Nonce)
+	}
+	if bytes.Compare(tx.Recipient, []byte("recipient")) != 0 {
+		t.Errorf("expected recipient 'recipient', got %q", tx.Recipient)
+	}
+	if tx.Value.Cmp(big.NewInt(256)) != 0 {
+		t.Errorf("expected value 256, got %v", tx.Value)
+	}
+	if tx.Memo != "memo" {
+		t.Errorf("expected memo 'memo', got %q", tx.Memo)
+	}
+	if tx.Header.Number != 42 || bytes.Compare(tx.Header.Hash, []byte{0xab}) != 0 {
+		t.Errorf("unexpected header %v", tx.Header)
+	}
+	if tx.Flag != 7 {
+		t.Errorf("expected flag 7, got %d", tx.Flag)
+	}
+
+	// Decoding from encoded data
+	var dec testDecodeTx
+	if err := NewValueFromBytes(value.Encode()).Decode(&dec); err != nil {
+		t.Fatal(err)
+	}
+	if dec.Nonce != 1 || dec.Value.Cmp(tx.Value) != 0 || dec.Header.Number != 42 || dec.Memo != "memo" {
+		t.Errorf("unexpected decoded tx %v", dec)
+	}
+}
+
+func TestValueDecodeErrors(t *testing.T) {
+	var tx testDecodeTx
+
+	if err := NewValue("str").Decode(&tx); err == nil {
+		t.Error("expected decoding a non slice to fail")
+	}
+	if err := NewValue([]interface{}{uint32(1)}).Decode(&tx); err == nil {
+		t.Error("expected decoding too few elements to fail")
+	}
+	if err := NewValue([]interface{}{1, []byte{}, []byte{}, "", "no slice", 1}).Decode(&tx); err == nil {
+		t.Error("expected decoding a nested struct from a non slice to fail")
+	}
+	if err := NewValue([]interface{}{}).Decode(tx); err == nil {
+		t.Error("expected decoding in to a non pointer to fail")
+	}
+
+	var header testDecodeHeader
+	if err := NewValue([]interface{}{[]byte{0x01, 0, 0, 0, 0, 0, 0, 0, 0}, []byte{}}).Decode(&header); err == nil {
+		t.Error("expected decoding a number larger than 64 bits to fail")
+	}
+	if err := NewValue([]interface{}{1, []byte{}, []byte{}, "", []interface{}{1, []byte{}}, 256}).Decode(&tx); err == nil {
+		t.Error("expected decoding an overflowing number to fail")
+	}
+	if err := NewValue([]interface{}{uint32(1), []byte{}, []byte{}, "", []interface{}{1, []byte{}}, []byte{0x01, 0x00}}).Decode(&tx); err == nil {
+		t.Error("expected decoding overflowing bytes to fail")
+	}
+
+	var unsupported struct {
+		Floats float64
+	}
+	if err := NewValue([]interface{}{1}).Decode(&unsupported); err == nil {
+		t.Error("expected decoding an unsupported type to fail")
+	}
+}
+
+func TestValueBigAmounts(t *testing.T) {
+	// 2^64 + 1 doesn't fit in 64 bits
+	amount := []byte{0x01, 0, 0, 0, 0, 0, 0, 0, 0x01}
+	value := NewValueFromBytes(Encode(amount))
+
+	exp := new(big.Int).Add(BigPow(2, 64), big.NewInt(1))
+	if value.BigInt().Cmp(exp) != 0 {
+		t.Errorf("expected BigInt to return %v, got %v", exp, value.BigInt())
+	}
+	if value.Uint() != 0 {
+		t.Errorf("expected Uint to return 0 for oversized numbers, got %d", value.Uint())
+	}
+
+	if num := NewValue([]byte{0x01, 0x00, 0x00}).Uint(); num != 65536 {
+		t.Errorf("expected Uint to return 65536, got %d", num)
+	}
+}
+
+func TestValueLen(t *testing.T) {
+	list := NewValue([]interface{}{1, 2, 3})
+	if list.Len() != 3 || list.ByteLen() != 0 {
+		t.Errorf("expected list Len 3 and ByteLen 0, got %d and %d", list.Len(), list.ByteLen())
+	}
+
+	byt := NewValue([]byte{1, 2, 3, 4})
+	if byt.Len() != 0 || byt.ByteLen() != 4 {
+		t.Errorf("expected byte slice Len 0 and ByteLen 4, got %d and %d", byt.Len(), byt.ByteLen())
+	}
+}
+
+func TestValueEncodeTo(t *testing.T) {
+	nested := NewValue([]interface{}{})
+	nested.Append("dog").AppendList().Append(1).Append([]byte{0x7f})
+	long := NewValue([]interface{}{bytes.Repeat([]byte{1}, 60), []interface{}{"cat", big.NewInt(1024)}})
+
+	tests := map[string]*Value{
+		"nested list": nested,
+		"long list":   long,
+		"empty list":  NewValue([]interface{}{}),
+		"nil":         NewValue(nil),
+		"byte":        NewValue([]byte{0x01}),
+		"bytes":       NewValue([]byte("a short byte slice")),
+		"long bytes":  NewValue(bytes.Repeat([]byte{0xff}, 1024)),
+		"big int":     NewValue(BigPow(2, 200)),
+		"zero":        NewValue(big.NewInt(0)),
+		"decoded":     NewValueFromBytes(Encode([]interface{}{"a", []interface{}{uint64(1), "b"}})),
+	}
+
+	for name, value := range tests {
+		var buff bytes.Buffer
+		n, err := value.EncodeTo(&buff)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+
+		exp := Encode(value.Val)
+		if bytes.Compare(buff.Bytes(), exp) != 0 || n != len(exp) {
+			t.Errorf("%s: expected %x (%d bytes), got %x (%d bytes)", name, exp, len(exp), buff.Bytes(), n)
+		}
+		if bytes.Compare(value.Encode(), exp) != 0 {
+			t.Errorf("%s: expected Encode to return %x, got %x", name, exp, value.Encode())
+		}
+	}
+}
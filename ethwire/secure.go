@@ -0,0 +1,341 @@
+package ethwire
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/ethereum/eth-go/ethutil"
+	"github.com/obscuren/secp256k1-go"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Encrypted connections:
+// Both sides send [4 bytes secure token] [32 bytes ephemeral key] in the
+// clear (initiator first). The ephemeral keys are combined using X25519 and
+// all following traffic is sent in AES-GCM sealed frames:
+// [4 bytes length] [sealed payload]
+// The first frame of each side is RLP([PUBKEY, SIGNATURE]) where the
+// signature is made with the node's secp256k1 key over the hash of both
+// ephemeral keys and its role. This authenticates the node behind the connection.
+
+// The magic token with which an encrypted connection starts. Connections
+// starting with the regular MagicToken are plaintext.
+var SecureToken = []byte{34, 64, 8, 146}
+
+// Returned by SecureHandshake if the remote doesn't answer with a SecureToken
+// (or hangs up right away), which is what nodes without encryption do.
+var ErrNotSecure = errors.New("Remote doesn't support encrypted connections")
+
+const (
+	// Largest frame which is accepted
+	maxFrameSize = 32 * 1024 * 1024
+	// Time the remote has to complete the handshake
+	handshakeTimeout = 10 * time.Second
+)
+
+// Secure conn is a net.Conn which encrypts and authenticates everything
+// written to and read from the underlying connection.
+type SecureConn struct {
+	net.Conn
+
+	// Secp256k1 public key of the remote node
+	RemotePubkey []byte
+
+	enc, dec       cipher.AEAD
+	wnonce, rnonce uint64
+	wmut           sync.Mutex
+
+	// Received bytes which don't form a complete frame yet
+	raw []byte
+	// Decrypted bytes which haven't been read yet
+	buf []byte
+}
+
+// Initiates an encrypted connection. The key pair is the node's secp256k1
+// key pair which is used to authenticate itself.
+func SecureHandshake(conn net.Conn, privk, pubkey []byte) (*SecureConn, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write(append(SecureToken, eph.PublicKey().Bytes()...)); err != nil {
+		return nil, err
+	}
+
+	token := make([]byte, len(SecureToken))
+	if _, err := io.ReadFull(conn, token); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrNotSecure
+		}
+		return nil, err
+	}
+	if bytes.Compare(token, SecureToken) != 0 {
+		return nil, ErrNotSecure
+	}
+
+	remote, err := readEphemeral(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newSecureConn(conn, eph, remote, true)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := transcript(eph.PublicKey(), remote)
+	if err := c.writeAuth(authHash(hash, true), privk, pubkey); err != nil {
+		return nil, err
+	}
+	if err := c.readAuth(authHash(hash, false)); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Accepts an incoming connection. If the remote initiates an encrypted
+// connection the handshake is completed and a *SecureConn is returned.
+// Otherwise the connection is returned as is, allowing plaintext peers to
+// connect.
+func AcceptHandshake(conn net.Conn, privk, pubkey []byte) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	token := make([]byte, len(SecureToken))
+	if _, err := io.ReadFull(conn, token); err != nil {
+		return nil, err
+	}
+	if bytes.Compare(token, SecureToken) != 0 {
+		return &prefixConn{Conn: conn, prefix: token}, nil
+	}
+
+	remote, err := readEphemeral(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write(append(SecureToken, eph.PublicKey().Bytes()...)); err != nil {
+		return nil, err
+	}
+
+	c, err := newSecureConn(conn, eph, remote, false)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := transcript(remote, eph.PublicKey())
+	if err := c.readAuth(authHash(hash, true)); err != nil {
+		return nil, err
+	}
+	if err := c.writeAuth(authHash(hash, false), privk, pubkey); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func readEphemeral(conn net.Conn) (*ecdh.PublicKey, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(conn, key); err != nil {
+		return nil, err
+	}
+
+	return ecdh.X25519().NewPublicKey(key)
+}
+
+func newSecureConn(conn net.Conn, eph *ecdh.PrivateKey, remote *ecdh.PublicKey, initiator bool) (*SecureConn, error) {
+	shared, err := eph.ECDH(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	initPub, respPub := eph.PublicKey(), remote
+	if !initiator {
+		initPub, respPub = remote, eph.PublicKey()
+	}
+
+	// Each direction has its own key
+	hash := transcript(initPub, respPub)
+	initKey := ethutil.Sha3Bin(bytes.Join([][]byte{shared, hash, []byte{1}}, nil))
+	respKey := ethutil.Sha3Bin(bytes.Join([][]byte{shared, hash, []byte{2}}, nil))
+	if !initiator {
+		initKey, respKey = respKey, initKey
+	}
+
+	c := &SecureConn{Conn: conn}
+	if c.enc, err = newAEAD(initKey); err != nil {
+		return nil, err
+	}
+	if c.dec, err = newAEAD(respKey); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Hash of both ephemeral keys
+func transcript(initPub, respPub *ecdh.PublicKey) []byte {
+	return ethutil.Sha3Bin(append(initPub.Bytes(), respPub.Bytes()...))
+}
+
+// Hash which is signed by either side. They differ so that an auth frame
+// can't be reflected back.
+func authHash(transcript []byte, initiator bool) []byte {
+	role := []byte{2}
+	if initiator {
+		role = []byte{1}
+	}
+
+	return ethutil.Sha3Bin(bytes.Join([][]byte{transcript, role}, nil))
+}
+
+func (c *SecureConn) writeAuth(hash, privk, pubkey []byte) error {
+	sig, err := secp256k1.Sign(hash, privk)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Write(ethutil.Encode([]interface{}{pubkey, sig}))
+
+	return err
+}
+
+func (c *SecureConn) readAuth(hash []byte) error {
+	if err := c.readFrame(); err != nil {
+		return err
+	}
+
+	auth := ethutil.NewValueFromBytes(c.buf)
+	c.buf = nil
+
+	pubkey, sig := auth.Get(0).Bytes(), auth.Get(1).Bytes()
+	recovered, err := secp256k1.RecoverPubkey(hash, sig)
+	if err != nil {
+		return err
+	}
+	if len(pubkey) == 0 || bytes.Compare(recovered, pubkey) != 0 {
+		return errors.New("Handshake signature doesn't match the remote's public key")
+	}
+
+	c.RemotePubkey = pubkey
+
+	return nil
+}
+
+func (c *SecureConn) nonce(n uint64) []byte {
+	nonce := make([]byte, c.enc.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], n)
+
+	return nonce
+}
+
+// Reads the next frame in to the read buffer. Bytes of incomplete frames
+// are kept so reads may time out halfway a frame.
+func (c *SecureConn) readFrame() error {
+	for {
+		if len(c.raw) >= 4 {
+			length := int(binary.BigEndian.Uint32(c.raw[:4]))
+			if length > maxFrameSize {
+				return fmt.Errorf("frame length %d exceeds the maximum of %d", length, maxFrameSize)
+			}
+
+			if len(c.raw) >= 4+length {
+				plain, err := c.dec.Open(nil, c.nonce(c.rnonce), c.raw[4:4+length], nil)
+				if err != nil {
+					return err
+				}
+				c.rnonce++
+				c.raw = c.raw[4+length:]
+				c.buf = plain
+
+				return nil
+			}
+		}
+
+		b := make([]byte, 4096)
+		n, err := c.Conn.Read(b)
+		c.raw = append(c.raw, b[:n]...)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *SecureConn) Read(b []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if err := c.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+
+	return n, nil
+}
+
+func (c *SecureConn) Write(b []byte) (int, error) {
+	if len(b) > maxFrameSize-c.enc.Overhead() {
+		return 0, fmt.Errorf("write of %d bytes exceeds the maximum frame size", len(b))
+	}
+
+	c.wmut.Lock()
+	defer c.wmut.Unlock()
+
+	sealed := c.enc.Seal(nil, c.nonce(c.wnonce), b, nil)
+	c.wnonce++
+
+	frame := make([]byte, 4, 4+len(sealed))
+	binary.BigEndian.PutUint32(frame, uint32(len(sealed)))
+	if _, err := c.Conn.Write(append(frame, sealed...)); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+// Connection which replays the bytes read while detecting the type of
+// connection before reading on
+type prefixConn struct {
+	net.Conn
+
+	prefix []byte
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+
+		return n, nil
+	}
+
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,105 @@
+package ethwire
+
+import (
+	"bytes"
+	"github.com/ethereum/eth-go/ethutil"
+	"github.com/obscuren/secp256k1-go"
+	"net"
+	"testing"
+)
+
+func TestSecureHandshake(t *testing.T) {
+	ethutil.ReadConfig(".ethtest")
+
+	client, server := net.Pipe()
+	clientPub, clientKey := secp256k1.GenerateKeyPair()
+	serverPub, serverKey := secp256k1.GenerateKeyPair()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := AcceptHandshake(server, serverKey, serverPub)
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+
+	conn, err := SecureHandshake(client, clientKey, clientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(conn.RemotePubkey, serverPub) != 0 {
+		t.Error("expected the remote to be authenticated with the server's key")
+	}
+
+	sconn, ok := (<-accepted).(*SecureConn)
+	if !ok {
+		t.Fatal("expected an encrypted connection")
+	}
+	if bytes.Compare(sconn.RemotePubkey, clientPub) != 0 {
+		t.Error("expected the remote to be authenticated with the client's key")
+	}
+
+	go WriteMessage(conn, NewMessage(MsgTalkTy, []interface{}{"hello"}))
+
+	msgs, err := ReadMessages(sconn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Type != MsgTalkTy || msgs[0].Data.Get(0).Str() != "hello" {
+		t.Errorf("unexpected messages %v", msgs)
+	}
+}
+
+func TestAcceptPlaintext(t *testing.T) {
+	ethutil.ReadConfig(".ethtest")
+
+	client, server := net.Pipe()
+	serverPub, serverKey := secp256k1.GenerateKeyPair()
+
+	go WriteMessage(client, NewMessage(MsgTalkTy, []interface{}{"hello"}))
+
+	conn, err := AcceptHandshake(server, serverKey, serverPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.(*SecureConn); ok {
+		t.Fatal("expected a plaintext connection")
+	}
+
+	msgs, err := ReadMessages(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Data.Get(0).Str() != "hello" {
+		t.Errorf("unexpected messages %v", msgs)
+	}
+}
+
+func TestSecureHandshakeNotSecure(t *testing.T) {
+	ethutil.ReadConfig(".ethtest")
+
+	clientPub, clientKey := secp256k1.GenerateKeyPair()
+
+	// Nodes without encryption either reply in plaintext or hang up
+	remotes := []func(net.Conn){
+		func(conn net.Conn) {
+			conn.Read(make([]byte, 36))
+			WriteMessage(conn, NewMessage(MsgHandshakeTy, []interface{}{"hello"}))
+		},
+		func(conn net.Conn) {
+			conn.Read(make([]byte, 36))
+			conn.Close()
+		},
+	}
+
+	for i, remote := range remotes {
+		client, server := net.Pipe()
+		go remote(server)
+
+		if _, err := SecureHandshake(client, clientKey, clientPub); err != ErrNotSecure {
+			t.Errorf("remote %d: expected ErrNotSecure, got %v", i, err)
+		}
+		client.Close()
+	}
+}
@@ -20,6 +20,15 @@ const (
 	outputBufferSize = 50
 )
 
+const (
+	// Version of the wire protocol. Peers using a different version are
+	// disconnected during the handshake
+	ProtocolVersion = 4
+	// Network the node is part of. Peers of other networks are disconnected
+	// during the handshake
+	NetworkId = 0
+)
+
 type DiscReason byte
 
 const (
@@ -104,10 +113,11 @@ type Peer struct {
 	disconnect int32
 	// Last known message send
 	lastSend time.Time
-	// Indicated whether a verack has been send or not
+	// Indicated whether a valid handshake has been received or not
 	// This flag is used by writeMessage to check if messages are allowed
 	// to be send or not. If no version is known all messages are ignored.
-	versionKnown bool
+	// Broadcasts skip peers of which no version is known.
+	versionKnown int32
 
 	// Last received pong message
 	lastPong int64
@@ -124,6 +134,9 @@ type Peer struct {
 	// Indicated whether the node is catching up or not
 	catchingUp bool
 
+	// Block height the peer reported during the handshake
+	blockHeight uint64
+
 	Version string
 }
 
@@ -159,8 +172,7 @@ func NewOutboundPeer(addr string, ethereum *Ethereum, caps Caps) *Peer {
 
 	// Set up the connection in another goroutine so we don't block the main thread
 	go func() {
-		conn, err := net.DialTimeout("tcp", addr, 30*time.Second)
-
+		conn, err := dialPeer(addr, ethereum.Encrypt, ethereum.AllowPlaintext)
 		if err != nil {
 			log.Println("Connection to peer failed", err)
 			p.Stop()
@@ -178,6 +190,34 @@ func NewOutboundPeer(addr string, ethereum *Ethereum, caps Caps) *Peer {
 	return p
 }
 
+// Connects to the given address. If encrypt is set the connection is
+// encrypted and fails if the remote doesn't support encryption, unless
+// allowPlaintext is set in which case it's dialed again in plaintext.
+func dialPeer(addr string, encrypt, allowPlaintext bool) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, 30*time.Second)
+	if err != nil || !encrypt {
+		return conn, err
+	}
+
+	data, _ := ethutil.Config.Db.Get([]byte("KeyRing"))
+	keyRing := ethutil.NewValueFromBytes(data)
+
+	sconn, err := ethwire.SecureHandshake(conn, keyRing.Get(0).Bytes(), keyRing.Get(2).Bytes())
+	if err != nil {
+		conn.Close()
+
+		if err == ethwire.ErrNotSecure && allowPlaintext {
+			log.Printf("WARNING: %s doesn't support encryption, reconnecting in PLAINTEXT\n", addr)
+
+			return net.DialTimeout("tcp", addr, 30*time.Second)
+		}
+
+		return nil, fmt.Errorf("Encryption handshake failed: %v", err)
+	}
+
+	return sconn, nil
+}
+
 // Outputs any RLP encoded data to the peer
 func (p *Peer) QueueMessage(msg *ethwire.Msg) {
 	p.outputQueue <- msg
@@ -189,7 +229,7 @@ func (p *Peer) writeMessage(msg *ethwire.Msg) {
 		return
 	}
 
-	if !p.versionKnown {
+	if !p.VersionKnown() {
 		switch msg.Type {
 		case ethwire.MsgHandshakeTy: // Ok
 		default: // Anything but ack is allowed
@@ -462,15 +502,23 @@ func (p *Peer) Stop() {
 	}
 }
 
-func (p *Peer) pushHandshake() error {
+// Returns whether a valid handshake has been received from the peer
+func (p *Peer) VersionKnown() bool {
+	return atomic.LoadInt32(&p.versionKnown) == 1
+}
+
+func (p *Peer) handshakeMessage() *ethwire.Msg {
 	data, _ := ethutil.Config.Db.Get([]byte("KeyRing"))
 	pubkey := ethutil.NewValueFromBytes(data).Get(2).Bytes()
 
-	msg := ethwire.NewMessage(ethwire.MsgHandshakeTy, []interface{}{
-		uint32(4), uint32(0), p.Version, byte(p.caps), p.port, pubkey,
+	return ethwire.NewMessage(ethwire.MsgHandshakeTy, []interface{}{
+		uint32(ProtocolVersion), uint32(NetworkId), p.Version, byte(p.caps), p.port, pubkey, ethchain.CurrentSigner().Id(),
+		p.ethereum.Nonce, p.ethereum.BlockManager.BlockChain().LastBlockNumber,
 	})
+}
 
-	p.QueueMessage(msg)
+func (p *Peer) pushHandshake() error {
+	p.QueueMessage(p.handshakeMessage())
 
 	return nil
 }
@@ -494,14 +542,46 @@ func (p *Peer) pushPeers() {
 func (p *Peer) handleHandshake(msg *ethwire.Msg) {
 	c := msg.Data
 
-	if c.Get(0).Uint() != 4 {
-		log.Println("Invalid peer version. Require protocol v4")
+	// [PROTOCOL_VERSION, NETWORK_ID, CLIENT_ID, CAPS, PORT, PUBKEY, SIGN_SCHEME, NONCE, BLOCK_HEIGHT]
+	if version := c.Get(0).Uint(); version != ProtocolVersion {
+		log.Printf("Invalid peer version %d. Require protocol v%d\n", version, ProtocolVersion)
+		p.Stop()
+		return
+	}
+
+	if network := c.Get(1).Uint(); network != NetworkId {
+		log.Printf("Incompatible network %d. Require network %d\n", network, NetworkId)
+		p.Stop()
+		return
+	}
+
+	// Both sides send the nonce of their node. Receiving our own nonce
+	// means we've connected to ourselves
+	if c.Get(7).Uint() == p.ethereum.Nonce {
+		log.Println("Connected to self, disconnecting")
+		p.Stop()
+		return
+	}
+
+	// Both peers should use the same transaction signature scheme. Peers
+	// which don't send one use the default (secp256k1)
+	var scheme byte
+	if c.Len() > 6 {
+		scheme = c.Get(6).Byte()
+	}
+	if scheme != ethchain.CurrentSigner().Id() {
+		log.Printf("Incompatible signature scheme %d. Require %d\n", scheme, ethchain.CurrentSigner().Id())
 		p.Stop()
 		return
 	}
 
-	// [PROTOCOL_VERSION, NETWORK_ID, CLIENT_ID, CAPS, PORT, PUBKEY]
-	p.versionKnown = true
+	// The key the peer claims to have should be the one it authenticated
+	// itself with while setting up the encrypted connection
+	if sconn, ok := p.conn.(*ethwire.SecureConn); ok && bytes.Compare(sconn.RemotePubkey, c.Get(5).Bytes()) != 0 {
+		log.Println("Peer's public key doesn't match its encryption handshake")
+		p.Stop()
+		return
+	}
 
 	// If this is an inbound connection send an ack back
 	if p.inbound {
@@ -512,6 +592,7 @@ func (p *Peer) handleHandshake(msg *ethwire.Msg) {
 		data, _ := ethutil.Config.Db.Get([]byte("KeyRing"))
 		pubkey := ethutil.NewValueFromBytes(data).Get(2).Bytes()
 		if bytes.Compare(pubkey, p.pubkey) == 0 {
+			log.Println("Peer uses our public key, disconnecting")
 			p.Stop()
 
 			return
@@ -519,6 +600,10 @@ func (p *Peer) handleHandshake(msg *ethwire.Msg) {
 
 	}
 
+	// The handshake is accepted, only now the peer joins the broadcasts
+	p.blockHeight = c.Get(8).Uint()
+	atomic.StoreInt32(&p.versionKnown, 1)
+
 	// Catch up with the connected peer
 	p.CatchupWithPeer()
 
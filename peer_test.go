@@ -0,0 +1,253 @@
+package eth
+
+import (
+	"bytes"
+	"container/list"
+	"github.com/ethereum/eth-go/ethchain"
+	"github.com/ethereum/eth-go/ethdb"
+	"github.com/ethereum/eth-go/ethutil"
+	"github.com/ethereum/eth-go/ethwire"
+	"github.com/obscuren/secp256k1-go"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testEthereum(nonce uint64) *Ethereum {
+	ethereum := &Ethereum{peers: list.New(), Nonce: nonce}
+	ethereum.BlockManager = ethchain.NewBlockManager(ethereum)
+
+	return ethereum
+}
+
+// Sends the handshake over an in-process connection and lets an inbound
+// peer of the local node handle it
+func testHandshake(t *testing.T, local *Ethereum, msg *ethwire.Msg) *Peer {
+	lconn, rconn := net.Pipe()
+	defer rconn.Close()
+
+	// The local node's key ring is used while handling the handshake
+	useKeyRing(localKey)
+	peer := NewPeer(lconn, local, true)
+
+	go func() {
+		ethwire.WriteMessage(rconn, msg)
+		// Drain anything the peer writes back, e.g. a disconnect
+		io.Copy(ioutil.Discard, rconn)
+	}()
+
+	var msgs []*ethwire.Msg
+	for i := 0; i < 50 && len(msgs) == 0; i++ {
+		var err error
+		if msgs, err = ethwire.ReadMessages(lconn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(msgs) != 1 || msgs[0].Type != ethwire.MsgHandshakeTy {
+		t.Fatalf("Expected a single handshake message, got %v", msgs)
+	}
+
+	peer.handleHandshake(msgs[0])
+
+	return peer
+}
+
+var (
+	localKey  = []byte("local pubkey")
+	remoteKey = []byte("remote pubkey")
+)
+
+// Stores a key ring with the given public key. The nodes in these tests
+// share the database so the key ring is swapped depending on which node acts.
+func useKeyRing(pubkey []byte) {
+	ethutil.Config.Db.Put([]byte("KeyRing"), ethutil.Encode([]interface{}{[]byte("privk"), []byte("addr"), pubkey}))
+}
+
+// Returns the handshake the remote node sends
+func remoteHandshake(remote *Ethereum) *ethwire.Msg {
+	useKeyRing(remoteKey)
+
+	return NewPeer(nil, remote, false).handshakeMessage()
+}
+
+func setupHandshakeTest() (local, remote *Ethereum) {
+	ethutil.ReadConfig(".ethtest")
+	db, _ := ethdb.NewMemDatabase()
+	ethutil.Config.Db = db
+
+	return testEthereum(1), testEthereum(2)
+}
+
+// Returns the remote's handshake with the element at index replaced
+func handshakeWith(remote *Ethereum, index int, value interface{}) *ethwire.Msg {
+	data := remoteHandshake(remote).Data.Slice()
+	data[index] = value
+
+	return ethwire.NewMessage(ethwire.MsgHandshakeTy, data)
+}
+
+func TestHandshake(t *testing.T) {
+	local, remote := setupHandshakeTest()
+
+	peer := testHandshake(t, local, remoteHandshake(remote))
+	if !peer.VersionKnown() || atomic.LoadInt32(&peer.disconnect) != 0 {
+		t.Error("Expected a matching handshake to be accepted")
+	}
+	if peer.blockHeight != remote.BlockManager.BlockChain().LastBlockNumber {
+		t.Errorf("Expected block height %d, got %d", remote.BlockManager.BlockChain().LastBlockNumber, peer.blockHeight)
+	}
+}
+
+func TestHandshakeRejected(t *testing.T) {
+	local, remote := setupHandshakeTest()
+
+	tests := map[string]*ethwire.Msg{
+		"version": handshakeWith(remote, 0, uint32(ProtocolVersion+1)),
+		"network": handshakeWith(remote, 1, uint32(NetworkId+1)),
+		"nonce":   handshakeWith(remote, 7, local.Nonce),
+		"pubkey":  handshakeWith(remote, 5, localKey),
+	}
+
+	for name, msg := range tests {
+		peer := testHandshake(t, local, msg)
+		if peer.VersionKnown() || atomic.LoadInt32(&peer.disconnect) == 0 {
+			t.Errorf("Expected handshake with mismatching %s to be rejected", name)
+		}
+	}
+}
+
+func TestBroadcastSkipsUnknownPeers(t *testing.T) {
+	local, _ := setupHandshakeTest()
+
+	known, unknown := NewPeer(nil, local, true), NewPeer(nil, local, true)
+	known.versionKnown = 1
+	local.peers.PushBack(known)
+	local.peers.PushBack(unknown)
+
+	local.Broadcast(ethwire.MsgPingTy, nil)
+
+	if len(known.outputQueue) != 1 {
+		t.Error("Expected the message to be queued for the handshaked peer")
+	}
+	if len(unknown.outputQueue) != 0 {
+		t.Error("Expected no message to be queued before the handshake")
+	}
+}
+
+// Listens on a local port and hands every accepted connection to handle
+func testListener(t *testing.T, handle func(net.Conn)) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			handle(conn)
+		}
+	}()
+
+	return ln
+}
+
+// Stores a key ring with a real secp256k1 key pair
+func useSecureKeyRing() {
+	pubkey, privk := secp256k1.GenerateKeyPair()
+	ethutil.Config.Db.Put([]byte("KeyRing"), ethutil.Encode([]interface{}{privk, []byte("addr"), pubkey}))
+}
+
+// Listens like a node without encryption support, which hangs up on
+// anything but the MagicToken. The tokens of all connections are sent on
+// the returned channel.
+func plaintextListener(t *testing.T) (net.Listener, chan []byte) {
+	tokens := make(chan []byte, 2)
+	ln := testListener(t, func(conn net.Conn) {
+		buff := make([]byte, 1024)
+		io.ReadAtLeast(conn, buff, 4)
+		token := buff[:4]
+		tokens <- token
+
+		if bytes.Compare(token, ethwire.MagicToken) != 0 {
+			conn.Close()
+		}
+	})
+
+	return ln, tokens
+}
+
+func TestDialPeerEncrypted(t *testing.T) {
+	setupHandshakeTest()
+	useSecureKeyRing()
+
+	remotePub, remotePriv := secp256k1.GenerateKeyPair()
+	ln := testListener(t, func(conn net.Conn) {
+		go ethwire.AcceptHandshake(conn, remotePriv, remotePub)
+	})
+	defer ln.Close()
+
+	conn, err := dialPeer(ln.Addr().String(), true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, ok := conn.(*ethwire.SecureConn); !ok {
+		t.Error("expected an encrypted connection")
+	}
+}
+
+func TestDialPeerRequiresEncryption(t *testing.T) {
+	setupHandshakeTest()
+	useSecureKeyRing()
+
+	ln, tokens := plaintextListener(t)
+	defer ln.Close()
+
+	if conn, err := dialPeer(ln.Addr().String(), true, false); err == nil {
+		conn.Close()
+		t.Fatal("expected the connection to a plaintext peer to fail")
+	}
+
+	if token := <-tokens; bytes.Compare(token, ethwire.SecureToken) != 0 {
+		t.Errorf("expected an encrypted connection to be attempted, got %v", token)
+	}
+	select {
+	case token := <-tokens:
+		t.Errorf("expected no plaintext connection, got one starting with %v", token)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDialPeerPlaintextFallback(t *testing.T) {
+	setupHandshakeTest()
+	useSecureKeyRing()
+
+	ln, tokens := plaintextListener(t)
+	defer ln.Close()
+
+	conn, err := dialPeer(ln.Addr().String(), true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, ok := conn.(*ethwire.SecureConn); ok {
+		t.Fatal("expected a plaintext connection")
+	}
+
+	// Plaintext connections speak the regular protocol
+	go ethwire.WriteMessage(conn, ethwire.NewMessage(ethwire.MsgPingTy, ""))
+	if token := <-tokens; bytes.Compare(token, ethwire.SecureToken) != 0 {
+		t.Errorf("expected an encrypted connection to be attempted first, got %v", token)
+	}
+	if token := <-tokens; bytes.Compare(token, ethwire.MagicToken) != 0 {
+		t.Errorf("expected the plaintext connection to start with the MagicToken, got %v", token)
+	}
+}
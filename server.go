@@ -7,29 +7,43 @@ import (
 	"github.com/ethereum/ethwire-go"
 	"log"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 func eachPeer(peers *list.List, callback func(*Peer, *list.Element)) {
 	// Loop thru the peers and close them (if we had them)
-	for e := peers.Front(); e != nil; e = e.Next() {
+	for e := peers.Front(); e != nil; {
+		// Get the next element before calling back so the callback may
+		// remove the current element from the list
+		next := e.Next()
 		if peer, ok := e.Value.(*Peer); ok {
 			callback(peer, e)
 		}
+		e = next
 	}
 }
 
 const (
 	processReapingTimeout = 60 // TODO increase
+	// Address the server listens on by default
+	defaultListenAddr = ":12345"
 )
 
 type Server struct {
-	// Channel for shutting down the server
-	shutdownChan chan bool
+	// Closed when the server shuts down. Every loop of the server exits
+	// once it's closed
+	done chan struct{}
+	// Makes sure the server is only shut down once
+	stopOnce sync.Once
+	// Running loops of the server
+	wg sync.WaitGroup
+	// Address to listen on and the listener once started
+	listenAddr string
+	listener   net.Listener
 	// DB interface
-	//db *ethdb.LDBDatabase
-	db *ethdb.MemDatabase
+	db ethutil.Database
 	// Block manager for processing new blocks and managing the block chain
 	blockManager *BlockManager
 	// The transaction pool. Transaction can be pushed on this pool
@@ -37,37 +51,56 @@ type Server struct {
 	txPool *TxPool
 	// Peers (NYI)
 	peers *list.List
+	// Guards peers. Peers are added, reaped and iterated from different
+	// goroutines
+	peerMut sync.RWMutex
 	// Nonce
 	Nonce uint64
 }
 
-func NewServer() (*Server, error) {
-	//db, err := ethdb.NewLDBDatabase()
+// Creates a server which persists the block chain and state in a LevelDB
+// database in the data directory
+func NewServer(dataDir string) (*Server, error) {
+	db, err := NewLDBDatabaseAt(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return newServer(db), nil
+}
+
+// Creates a server backed by an in memory database. Nothing is persisted.
+func NewTestServer() (*Server, error) {
 	db, err := ethdb.NewMemDatabase()
 	if err != nil {
 		return nil, err
 	}
 
+	return newServer(db), nil
+}
+
+func newServer(db ethutil.Database) *Server {
 	ethutil.Config.Db = db
 
 	nonce, _ := ethutil.RandomUint64()
 	server := &Server{
-		shutdownChan: make(chan bool),
-		db:           db,
-		peers:        list.New(),
-		Nonce:        nonce,
+		done:       make(chan struct{}),
+		listenAddr: defaultListenAddr,
+		db:         db,
+		peers:      list.New(),
+		Nonce:      nonce,
 	}
 	server.txPool = NewTxPool(server)
 	server.blockManager = NewBlockManager(server)
 
-	return server, nil
+	return server
 }
 
 func (s *Server) AddPeer(conn net.Conn) {
 	peer := NewPeer(conn, s, true)
 
 	if peer != nil {
-		s.peers.PushBack(peer)
+		s.pushPeer(peer)
 		peer.Start()
 
 		log.Println("Peer connected ::", conn.RemoteAddr())
@@ -84,63 +117,108 @@ func (s *Server) ProcessPeerList(addrs []string) {
 func (s *Server) ConnectToPeer(addr string) error {
 	peer := NewOutboundPeer(addr, s)
 
-	s.peers.PushBack(peer)
+	s.pushPeer(peer)
 
 	return nil
 }
 
-func (s *Server) OutboundPeers() []*Peer {
-	// Create a new peer slice with at least the length of the total peers
-	outboundPeers := make([]*Peer, s.peers.Len())
-	length := 0
-	eachPeer(s.peers, func(p *Peer, e *list.Element) {
-		if !p.inbound {
-			outboundPeers[length] = p
-			length++
-		}
-	})
+func (s *Server) pushPeer(peer *Peer) {
+	s.peerMut.Lock()
+	defer s.peerMut.Unlock()
 
-	return outboundPeers[:length]
+	s.peers.PushBack(peer)
 }
 
-func (s *Server) InboundPeers() []*Peer {
+// Returns a snapshot of all peers for which the filter returns true
+func (s *Server) filterPeers(filter func(*Peer) bool) []*Peer {
+	s.peerMut.RLock()
+	defer s.peerMut.RUnlock()
+
 	// Create a new peer slice with at least the length of the total peers
-	inboundPeers := make([]*Peer, s.peers.Len())
-	length := 0
+	peers := make([]*Peer, 0, s.peers.Len())
 	eachPeer(s.peers, func(p *Peer, e *list.Element) {
-		if p.inbound {
-			inboundPeers[length] = p
-			length++
+		if filter(p) {
+			peers = append(peers, p)
 		}
 	})
 
-	return inboundPeers[:length]
+	return peers
+}
+
+// Returns a snapshot of all peers
+func (s *Server) Peers() []*Peer {
+	return s.filterPeers(func(p *Peer) bool { return true })
+}
+
+func (s *Server) OutboundPeers() []*Peer {
+	return s.filterPeers(func(p *Peer) bool { return !p.inbound })
+}
+
+func (s *Server) InboundPeers() []*Peer {
+	return s.filterPeers(func(p *Peer) bool { return p.inbound })
 }
 
 func (s *Server) Broadcast(msgType ethwire.MsgType, data []byte) {
-	eachPeer(s.peers, func(p *Peer, e *list.Element) {
+	// Messages are queued on a snapshot so the lock isn't held while
+	// a peer's queue is full
+	for _, p := range s.Peers() {
 		p.QueueMessage(ethwire.NewMessage(msgType, data))
+	}
+}
+
+func (s *Server) reapPeers() {
+	s.peerMut.Lock()
+	defer s.peerMut.Unlock()
+
+	eachPeer(s.peers, func(p *Peer, e *list.Element) {
+		if atomic.LoadInt32(&p.disconnect) == 1 || (p.inbound && (time.Now().Unix()-p.lastPong) > int64(5*time.Minute)) {
+			log.Println("Dead peer found .. reaping")
+
+			s.peers.Remove(e)
+		}
 	})
 }
 
+// Reaps dead peers until the server is stopped
 func (s *Server) ReapDeadPeers() {
+	reapTimer := time.NewTicker(processReapingTimeout * time.Second)
+	defer reapTimer.Stop()
+
 	for {
-		eachPeer(s.peers, func(p *Peer, e *list.Element) {
-			if atomic.LoadInt32(&p.disconnect) == 1 || (p.inbound && (time.Now().Unix()-p.lastPong) > int64(5*time.Minute)) {
-				log.Println("Dead peer found .. reaping")
+		s.reapPeers()
 
-				s.peers.Remove(e)
+		select {
+		case <-reapTimer.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Accepts connections until the server is stopped
+func (s *Server) acceptPeers(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
 			}
-		})
 
-		time.Sleep(processReapingTimeout * time.Second)
+			log.Println(err)
+
+			continue
+		}
+
+		go s.AddPeer(conn)
 	}
 }
 
 // Start the server
 func (s *Server) Start() {
 	// For now this function just blocks the main thread
-	ln, err := net.Listen("tcp", ":12345")
+	ln, err := net.Listen("tcp", s.listenAddr)
 	if err != nil {
 		// This is mainly for testing to create a "network"
 		if Debug {
@@ -151,28 +229,29 @@ func (s *Server) Start() {
 				log.Println("Error starting server", err)
 
 				s.Stop()
+
+				return
 			}
 		} else {
 			log.Fatal(err)
 		}
 	} else {
+		s.listener = ln
+
 		// Starting accepting connections
+		s.wg.Add(1)
 		go func() {
-			for {
-				conn, err := ln.Accept()
-				if err != nil {
-					log.Println(err)
-
-					continue
-				}
-
-				go s.AddPeer(conn)
-			}
+			defer s.wg.Done()
+			s.acceptPeers(ln)
 		}()
 	}
 
 	// Start the reaping processes
-	go s.ReapDeadPeers()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.ReapDeadPeers()
+	}()
 
 	// Start the tx pool
 	s.txPool.Start()
@@ -189,20 +268,30 @@ func (s *Server) Start() {
 	*/
 }
 
+// Stops the server. It's safe to call Stop more than once and from any
+// goroutine, only the first call shuts the server down.
 func (s *Server) Stop() {
-	// Close the database
-	defer s.db.Close()
+	s.stopOnce.Do(func() {
+		// Signal all loops to quit
+		close(s.done)
 
-	eachPeer(s.peers, func(p *Peer, e *list.Element) {
-		p.Stop()
-	})
+		if s.listener != nil {
+			s.listener.Close()
+		}
 
-	s.shutdownChan <- true
+		for _, p := range s.Peers() {
+			p.Stop()
+		}
+
+		s.txPool.Stop()
 
-	s.txPool.Stop()
+		// Wait for the loops before closing the database they may use
+		s.wg.Wait()
+		s.db.Close()
+	})
 }
 
 // This function will wait for a shutdown and resumes main thread execution
 func (s *Server) WaitForShutdown() {
-	<-s.shutdownChan
+	<-s.done
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"github.com/ethereum/ethutil-go"
+	"github.com/ethereum/ethwire-go"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServerPeersConcurrency(t *testing.T) {
+	s := &Server{peers: list.New()}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+
+		// Add peers of which every other one died. The queue fits every
+		// broadcast so queueing never blocks
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				p := &Peer{inbound: j%3 == 0, outputQueue: make(chan *ethwire.Msg, 500)}
+				if j%2 == 0 {
+					p.disconnect = 1
+				}
+				s.pushPeer(p)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				s.reapPeers()
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				s.Broadcast(ethwire.MsgPingTy, nil)
+				s.InboundPeers()
+				s.OutboundPeers()
+			}
+		}()
+	}
+	wg.Wait()
+
+	s.reapPeers()
+	if len(s.Peers()) != 200 {
+		t.Errorf("Expected 200 live peers, got %d", len(s.Peers()))
+	}
+	if len(s.InboundPeers())+len(s.OutboundPeers()) != 200 {
+		t.Error("Expected every peer to be either inbound or outbound")
+	}
+}
+
+func TestServerPersistence(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "ethereum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	s, err := NewServer(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A block on top of the genesis block. The lowest difficulty accepts
+	// any nonce.
+	genesis := s.blockManager.bc.GenesisBlock()
+	block := ethutil.NewBlock(genesis.RlpEncode())
+	block.PrevHash = string(genesis.Hash())
+	block.Time = genesis.Time + 1
+	block.Difficulty = big.NewInt(1)
+	if err := s.blockManager.ProcessBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	td := new(big.Int).Set(s.blockManager.bc.TD)
+	s.Stop()
+
+	s, err = NewServer(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	bm := s.blockManager
+	if !bm.bc.HasBlock(string(block.Hash())) {
+		t.Error("Expected the block to be loaded from disk")
+	}
+	if bytes.Compare(bm.bc.LastBlock.Hash(), block.Hash()) != 0 {
+		t.Errorf("Expected head %x, got %x", block.Hash(), bm.bc.LastBlock.Hash())
+	}
+	if bm.LastBlockNumber.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("Expected head number 1, got %v", bm.LastBlockNumber)
+	}
+	if bm.bc.TD.Cmp(td) != 0 {
+		t.Errorf("Expected TD %v, got %v", td, bm.bc.TD)
+	}
+}
+
+func TestNewServerFailingDatabase(t *testing.T) {
+	// A file where the data directory should be
+	file, err := ioutil.TempFile("", "ethereum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if _, err := NewServer(file.Name()); err == nil {
+		t.Error("Expected opening the database to fail")
+	}
+}
+
+func TestServerStop(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	s, err := NewTestServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.listenAddr = "127.0.0.1:0"
+	s.Start()
+
+	shutdown := make(chan struct{})
+	go func() {
+		s.WaitForShutdown()
+		close(shutdown)
+	}()
+
+	// Stopping twice shouldn't block or panic
+	s.Stop()
+	s.Stop()
+
+	select {
+	case <-shutdown:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected WaitForShutdown to return")
+	}
+
+	// The tx pool's handler isn't waited for, give it a moment to exit
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("Expected all goroutines to exit, %d of %d left running", n-before, n)
+	}
+}
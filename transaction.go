@@ -2,11 +2,11 @@ package main
 
 import (
   "math/big"
-  "fmt"
   "github.com/obscuren/secp256k1-go"
   _"encoding/hex"
   _"crypto/sha256"
-  _ "bytes"
+  "bytes"
+  "errors"
 )
 
 /*
@@ -15,10 +15,10 @@ Transaction   Contract       Size
 sender        sender       20 bytes
 recipient     0x0          20 bytes
 value         endowment     4 bytes (uint32)
-fee           fee           4 bytes (uint32)
+fee           fee           *big.Int
 d_size        o_size        4 bytes (uint32)
 data          ops           *
-signature     signature    64 bytes
+signature     signature    65 bytes
 */
 
 var StepFee     *big.Int = new(big.Int)
@@ -39,18 +39,17 @@ type Transaction struct {
   sender      string
   recipient   string
   value       uint64
-  fee         uint32
+  fee         *big.Int
   data        []string
   memory      []int
   lastTx      string
-  v           uint32
-  r, s        []byte
+  // r (32 bytes), s (32 bytes) and the recovery id (1 byte)
+  signature   []byte
 }
 
 func NewTransaction(to string, value uint64, data []string) *Transaction {
-  tx := Transaction{sender: "1234567890", recipient: to, value: value}
+  tx := Transaction{recipient: to, value: value}
   tx.nonce = "0"
-  tx.fee = 0//uint32((ContractFee + MemoryFee * float32(len(tx.data))) * 1e8)
   tx.lastTx = "0"
 
   // Serialize the data
@@ -64,102 +63,147 @@ func NewTransaction(to string, value uint64, data []string) *Transaction {
     tx.data[i] = instr
   }
 
-  tx.Sign([]byte("privkey"))
-  tx.Sender()
-
+  tx.fee = tx.CalculateFee()
 
   return &tx
 }
 
-func (tx *Transaction) Hash() []byte {
-  preEnc := []interface{}{
-    tx.nonce,
-    tx.recipient,
-    tx.value,
-    tx.fee,
-    tx.data,
+// The fee is the base fee plus the data fee for each instruction. Contract
+// creations pay the contract fee as base fee instead.
+func (tx *Transaction) CalculateFee() *big.Int {
+  fee := new(big.Int)
+  if tx.IsContract() {
+    fee.Set(ContractFee)
+  } else {
+    fee.Set(TxFee)
   }
 
-  return Sha256Bin(Encode(preEnc))
+  dataFee := new(big.Int).Mul(DataFee, big.NewInt(int64(len(tx.data))))
+
+  return fee.Add(fee, dataFee)
 }
 
-func (tx *Transaction) IsContract() bool {
-  return tx.recipient == ""
+// Canonical hash of the (signed) transaction which identifies it
+func (tx *Transaction) Hash() []byte {
+  return Hash(tx.MarshalRlp())
 }
 
-func (tx *Transaction) Signature(key []byte) []byte {
-  hash := tx.Hash()
-  sec  := Sha256Bin(key)
+// Hash of the transaction without its signature. This is the hash which
+// is signed.
+func (tx *Transaction) SigHash() []byte {
+  return Hash(tx.MarshalUnsignedRlp())
+}
 
-  sig, _ := secp256k1.Sign(hash, sec)
+// Transactions without recipient or to the zero address create a contract
+func (tx *Transaction) IsContract() bool {
+  return tx.recipient == "" || tx.recipient == string(make([]byte, 20))
+}
 
-  return sig
+func (tx *Transaction) Signature(key []byte) ([]byte, error) {
+  return secp256k1.Sign(tx.SigHash(), key)
 }
 
 func (tx *Transaction) PublicKey() []byte {
-  hash := Sha256Bin(tx.Hash())
-  sig  := append(tx.r, tx.s...)
+  if len(tx.signature) != 65 {
+    return nil
+  }
 
-  pubkey, _ := secp256k1.RecoverPubkey(hash, sig)
+  pubkey, _ := secp256k1.RecoverPubkey(tx.SigHash(), tx.signature)
 
   return pubkey
 }
 
+// Returns the address recovered from the signature
 func (tx *Transaction) Sender() []byte {
   pubkey := tx.PublicKey()
 
   // Validate the returned key.
   // Return nil if public key isn't in full format (04 = full, 03 = compact)
-  if pubkey[0] != 4 {
+  if len(pubkey) != 65 || pubkey[0] != 4 {
     return nil
   }
 
   return Sha256Bin(pubkey[1:65])[12:]
 }
 
-func (tx *Transaction) Sign(privk []byte) {
-  sig  := tx.Signature(privk)
+// Signs the transaction with the (32 byte) secp256k1 private key and sets
+// the sender to the address belonging to the key
+func (tx *Transaction) Sign(privk []byte) error {
+  sig, err := tx.Signature(privk)
+  if err != nil {
+    return err
+  }
+  if len(sig) != 65 {
+    return errors.New("Invalid signature length")
+  }
 
-  // Add 27 so we get either 27 or 28 (for positive and negative)
-  tx.v = uint32(sig[64]) + 27
-  tx.r = sig[:32]
-  tx.s = sig[32:65]
+  tx.signature = sig
+
+  sender := tx.Sender()
+  if sender == nil {
+    tx.signature = nil
+
+    return errors.New("Unable to recover the sender from the signature")
+  }
+  tx.sender = string(sender)
+
+  return nil
 }
 
-func (tx *Transaction) MarshalRlp() []byte {
-  // Prepare the transaction for serialization
-  preEnc := []interface{}{
+// Verifies that the signature was made by the sender of the transaction.
+// The sender is sent along with the transaction so a transaction which is
+// altered on the way recovers to a different address and is rejected.
+func (tx *Transaction) Verify() bool {
+  sender := tx.Sender()
+
+  return sender != nil && bytes.Compare(sender, []byte(tx.sender)) == 0
+}
+
+func (tx *Transaction) rlpData() []interface{} {
+  return []interface{}{
     tx.nonce,
     tx.recipient,
     tx.value,
     tx.fee,
     tx.data,
-    tx.v,
-    tx.r,
-    tx.s,
   }
+}
+
+// Encodes the transaction without signature
+func (tx *Transaction) MarshalUnsignedRlp() []byte {
+  return Encode(tx.rlpData())
+}
 
-  return Encode(preEnc)
+// Encodes the signed transaction followed by the claimed sender and the
+// signature (the last element)
+func (tx *Transaction) MarshalRlp() []byte {
+  // Prepare the transaction for serialization
+  return Encode(append(tx.rlpData(), tx.sender, tx.signature))
 }
 
 func (tx *Transaction) UnmarshalRlp(data []byte) {
   decoder := NewRlpDecoder(data)
 
   tx.nonce = decoder.Get(0).AsString()
-  tx.recipient = decoder.Get(0).AsString()
+  tx.recipient = decoder.Get(1).AsString()
   tx.value = decoder.Get(2).AsUint()
-  tx.fee = uint32(decoder.Get(3).AsUint())
+  tx.fee = decoder.Get(3).AsBigInt()
 
   d := decoder.Get(4)
   tx.data = make([]string, d.Length())
-  fmt.Println(d.Get(0))
   for i := 0; i < d.Length(); i++ {
     tx.data[i] = d.Get(i).AsString()
   }
 
-  tx.v = uint32(decoder.Get(5).AsUint())
-  tx.r = decoder.Get(6).AsBytes()
-  tx.s = decoder.Get(7).AsBytes()
+  // The sender is what the transaction claims, Verify checks it against
+  // the signature
+  tx.sender = decoder.Get(5).AsString()
+  tx.signature = decoder.Get(6).AsBytes()
+}
+
+func init() {
+  // Fees have to be known before any transaction is created
+  InitFees()
 }
 
 func InitFees() {
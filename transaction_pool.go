@@ -1,31 +1,29 @@
 package main
 
 import (
-	"bytes"
 	"container/list"
 	"errors"
+	"fmt"
 	"github.com/ethereum/ethutil-go"
 	"github.com/ethereum/ethwire-go"
 	"log"
 	"math/big"
+	"strconv"
 	"sync"
 )
 
 const (
 	txPoolQueueSize = 50
+	// Default amount of transactions the pool accepts
+	DefaultTxPoolCapacity = 1000
 )
 
-func FindTx(pool *list.List, finder func(*ethutil.Transaction, *list.Element) bool) *ethutil.Transaction {
-	for e := pool.Front(); e != nil; e = e.Next() {
-		if tx, ok := e.Value.(*ethutil.Transaction); ok {
-			if finder(tx, e) {
-				return tx
-			}
-		}
-	}
-
-	return nil
-}
+var (
+	ErrTxInvalidSig = errors.New("Transaction signature doesn't verify")
+	ErrTxKnown      = errors.New("Transaction is already in the pool")
+	ErrTxZeroValue  = errors.New("Transaction doesn't transfer any value")
+	ErrTxPoolFull   = errors.New("Transaction pool is full")
+)
 
 // The tx pool a thread safe transaction pool handler. In order to
 // guarantee a non blocking pool we use a queue channel which can be
@@ -38,36 +36,92 @@ type TxPool struct {
 	mutex sync.Mutex
 	// Queueing channel for reading and writing incoming
 	// transactions to
-	queueChan chan *ethutil.Transaction
+	queueChan chan *Transaction
 	// Quiting channel
 	quit chan bool
 
-	pool *list.List
+	// Accepted transactions, keyed by sender and hash for deduplication
+	// and kept in order of arrival
+	txs     map[string]*Transaction
+	ordered *list.List
+	// Maximum amount of transactions the pool accepts
+	Capacity int
 }
 
 func NewTxPool(s *Server) *TxPool {
 	return &TxPool{
 		server:    s,
 		mutex:     sync.Mutex{},
-		pool:      list.New(),
-		queueChan: make(chan *ethutil.Transaction, txPoolQueueSize),
+		queueChan: make(chan *Transaction, txPoolQueueSize),
 		quit:      make(chan bool),
+		txs:       make(map[string]*Transaction),
+		ordered:   list.New(),
+		Capacity:  DefaultTxPoolCapacity,
 	}
 }
 
-// Blocking function. Don't use directly. Use QueueTransaction instead
-func (pool *TxPool) addTransaction(tx *ethutil.Transaction) {
+// Validates the transaction and adds it to the pool. Transactions with an
+// invalid signature, which are already known, which transfer nothing or
+// which don't fit in the pool are rejected, as are transactions of which
+// the funds can't be processed. Accepted transactions are broadcasted to
+// the peers.
+func (pool *TxPool) Add(tx *Transaction) error {
+	if !tx.Verify() {
+		return ErrTxInvalidSig
+	}
+
+	if tx.value == 0 && !tx.IsContract() {
+		return ErrTxZeroValue
+	}
+
+	// Transactions are identical if the sender signed the same content.
+	// The signature itself isn't part of the key since signing the same
+	// content twice may give different signatures.
+	hash := tx.sender + string(tx.SigHash())
+
 	pool.mutex.Lock()
-	pool.pool.PushBack(tx)
+	if _, ok := pool.txs[hash]; ok {
+		pool.mutex.Unlock()
+
+		return ErrTxKnown
+	}
+	if len(pool.txs) >= pool.Capacity {
+		pool.mutex.Unlock()
+
+		return ErrTxPoolFull
+	}
+	if err := pool.processTransaction(tx); err != nil {
+		pool.mutex.Unlock()
+
+		return err
+	}
+
+	pool.txs[hash] = tx
+	pool.ordered.PushBack(tx)
 	pool.mutex.Unlock()
 
 	// Broadcast the transaction to the rest of the peers
-	pool.server.Broadcast(ethwire.MsgTxTy, tx.RlpEncode())
+	pool.server.Broadcast(ethwire.MsgTxTy, tx.MarshalRlp())
+
+	return nil
+}
+
+// Returns the transactions accepted through Add in order of arrival
+func (pool *TxPool) Pending() []*Transaction {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	txs := make([]*Transaction, 0, pool.ordered.Len())
+	for e := pool.ordered.Front(); e != nil; e = e.Next() {
+		txs = append(txs, e.Value.(*Transaction))
+	}
+
+	return txs
 }
 
 // Process transaction validates the Tx and processes funds from the
 // sender to the recipient.
-func (pool *TxPool) processTransaction(tx *ethutil.Transaction) error {
+func (pool *TxPool) processTransaction(tx *Transaction) error {
 	// Get the last block so we can retrieve the sender and receiver from
 	// the merkle trie
 	block := pool.server.blockManager.bc.LastBlock
@@ -87,12 +141,22 @@ func (pool *TxPool) processTransaction(tx *ethutil.Transaction) error {
 	} else {
 		sender = ethutil.NewEtherFromData([]byte(data))
 	}
-	// Defer the update. Whatever happens it should be persisted
-	defer block.State().Update(string(tx.Sender()), string(sender.RlpEncode()))
+	// Defer the update. Whatever happens it should be persisted. The
+	// account is encoded once the function returns, not when deferring
+	defer func() {
+		block.State().Update(string(tx.Sender()), string(sender.RlpEncode()))
+	}()
+
+	// The nonce makes each tx valid only once and in order
+	if tx.nonce != strconv.FormatUint(sender.Nonce, 10) {
+		return fmt.Errorf("Invalid nonce %s(%d)", tx.nonce, sender.Nonce)
+	}
+
+	value := new(big.Int).SetUint64(tx.value)
 
 	// Make sure there's enough in the sender's account. Having insufficient
 	// funds won't invalidate this transaction but simple ignores it.
-	if sender.Amount.Cmp(tx.Value) < 0 {
+	if sender.Amount.Cmp(value) < 0 {
 		if Debug {
 			log.Println("Insufficient amount in sender's account. Adding 1 ETH for debug")
 			sender.Amount = ethutil.BigPow(10, 18)
@@ -102,13 +166,13 @@ func (pool *TxPool) processTransaction(tx *ethutil.Transaction) error {
 	}
 
 	// Subtract the amount from the senders account
-	sender.Amount.Sub(sender.Amount, tx.Value)
+	sender.Amount.Sub(sender.Amount, value)
 	// Increment the nonce making each tx valid only once to prevent replay
 	// attacks
 	sender.Nonce += 1
 
 	// Get the receiver
-	data = block.State().Get(tx.Recipient)
+	data = block.State().Get(tx.recipient)
 	// If the receiver doesn't exist yet, create a new account to which the
 	// funds will be send.
 	if data == "" {
@@ -117,10 +181,12 @@ func (pool *TxPool) processTransaction(tx *ethutil.Transaction) error {
 		receiver = ethutil.NewEtherFromData([]byte(data))
 	}
 	// Defer the update
-	defer block.State().Update(tx.Recipient, string(receiver.RlpEncode()))
+	defer func() {
+		block.State().Update(tx.recipient, string(receiver.RlpEncode()))
+	}()
 
 	// Add the amount to receivers account which should conclude this transaction
-	receiver.Amount.Add(receiver.Amount, tx.Value)
+	receiver.Amount.Add(receiver.Amount, value)
 
 	return nil
 }
@@ -130,23 +196,10 @@ out:
 	for {
 		select {
 		case tx := <-pool.queueChan:
-			hash := tx.Hash()
-			foundTx := FindTx(pool.pool, func(tx *ethutil.Transaction, e *list.Element) bool {
-				return bytes.Compare(tx.Hash(), hash) == 0
-			})
-
-			if foundTx != nil {
-				break
-			}
-
-			// Process the transaction
-			err := pool.processTransaction(tx)
-			if err != nil {
+			// Call blocking version. At this point it
+			// doesn't matter since this is a goroutine
+			if err := pool.Add(tx); err != nil {
 				log.Println("Error processing Tx", err)
-			} else {
-				// Call blocking version. At this point it
-				// doesn't matter since this is a goroutine
-				pool.addTransaction(tx)
 			}
 		case <-pool.quit:
 			break out
@@ -154,7 +207,9 @@ out:
 	}
 }
 
-func (pool *TxPool) QueueTransaction(tx *ethutil.Transaction) {
+// Queues the transaction to be validated and added to the pool by the
+// pool's handler. See Add.
+func (pool *TxPool) QueueTransaction(tx *Transaction) {
 	pool.queueChan <- tx
 }
 
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"github.com/ethereum/ethutil-go"
+	"github.com/ethereum/ethwire-go"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Returns the pool of a test server with a single peer which receives the
+// broadcasts. The sender of the test key holds 1000 Wei.
+func setupTxPool(t *testing.T) (*TxPool, *Peer) {
+	server, err := NewTestServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := &Peer{outputQueue: make(chan *ethwire.Msg, 10)}
+	server.pushPeer(peer)
+
+	sender := signedTx(t, 0, 1).Sender()
+	account := ethutil.NewEther(big.NewInt(1000))
+	server.blockManager.bc.LastBlock.State().Update(string(sender), string(account.RlpEncode()))
+
+	return server.txPool, peer
+}
+
+func signedTx(t *testing.T, nonce, value uint64) *Transaction {
+	tx := NewTransaction("recipient", value, nil)
+	tx.nonce = strconv.FormatUint(nonce, 10)
+	if err := tx.Sign(testTxKey); err != nil {
+		t.Fatal(err)
+	}
+
+	return tx
+}
+
+func TestTxPoolAdd(t *testing.T) {
+	pool, peer := setupTxPool(t)
+
+	tx := signedTx(t, 0, 100)
+	if err := pool.Add(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	if pending := pool.Pending(); len(pending) != 1 || pending[0] != tx {
+		t.Errorf("Expected the transaction to be pending, got %v", pending)
+	}
+	if len(peer.outputQueue) != 1 {
+		t.Error("Expected the transaction to be broadcasted")
+	}
+}
+
+func TestTxPoolAddDuplicate(t *testing.T) {
+	pool, peer := setupTxPool(t)
+
+	if err := pool.Add(signedTx(t, 0, 100)); err != nil {
+		t.Fatal(err)
+	}
+	// Signing the same transaction again is still a duplicate
+	if err := pool.Add(signedTx(t, 0, 100)); err != ErrTxKnown {
+		t.Errorf("Expected %v, got %v", ErrTxKnown, err)
+	}
+
+	if len(pool.Pending()) != 1 || len(peer.outputQueue) != 1 {
+		t.Error("Expected the duplicate not to be added or broadcasted")
+	}
+}
+
+func TestTxPoolAddInvalid(t *testing.T) {
+	pool, peer := setupTxPool(t)
+
+	tampered := signedTx(t, 0, 100)
+	tampered.value = 200
+	if err := pool.Add(tampered); err != ErrTxInvalidSig {
+		t.Errorf("Expected %v, got %v", ErrTxInvalidSig, err)
+	}
+
+	if err := pool.Add(NewTransaction("recipient", 100, nil)); err != ErrTxInvalidSig {
+		t.Errorf("Expected unsigned transaction to fail with %v, got %v", ErrTxInvalidSig, err)
+	}
+
+	if err := pool.Add(signedTx(t, 0, 0)); err != ErrTxZeroValue {
+		t.Errorf("Expected %v, got %v", ErrTxZeroValue, err)
+	}
+
+	if err := pool.Add(signedTx(t, 1, 100)); err == nil {
+		t.Error("Expected a transaction with a future nonce to fail")
+	}
+
+	if err := pool.Add(signedTx(t, 0, 2000)); err == nil {
+		t.Error("Expected a transaction exceeding the sender's funds to fail")
+	}
+
+	if len(pool.Pending()) != 0 || len(peer.outputQueue) != 0 {
+		t.Error("Expected invalid transactions not to be added or broadcasted")
+	}
+}
+
+func TestTxPoolAddFull(t *testing.T) {
+	pool, _ := setupTxPool(t)
+	pool.Capacity = 2
+
+	for i := uint64(0); i < 2; i++ {
+		if err := pool.Add(signedTx(t, i, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pool.Add(signedTx(t, 2, 100)); err != ErrTxPoolFull {
+		t.Errorf("Expected %v, got %v", ErrTxPoolFull, err)
+	}
+	if len(pool.Pending()) != 2 {
+		t.Errorf("Expected 2 pending transactions, got %d", len(pool.Pending()))
+	}
+}
+
+func TestTxPoolQueue(t *testing.T) {
+	pool, peer := setupTxPool(t)
+	pool.Start()
+	defer pool.Stop()
+
+	tampered := signedTx(t, 0, 100)
+	tampered.value = 200
+	pool.QueueTransaction(tampered)
+	pool.QueueTransaction(NewTransaction("recipient", 100, nil))
+
+	// The handler processes the queue in order. Once the valid transaction
+	// is pending the invalid ones have been handled as well.
+	tx := signedTx(t, 0, 100)
+	pool.QueueTransaction(tx)
+	for i := 0; i < 100 && len(pool.Pending()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if pending := pool.Pending(); len(pending) != 1 || pending[0] != tx {
+		t.Errorf("Expected only the signed transaction to be pending, got %v", pending)
+	}
+	if len(peer.outputQueue) != 1 {
+		t.Errorf("Expected only the signed transaction to be broadcasted, got %d broadcasts", len(peer.outputQueue))
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+  "bytes"
+  "encoding/hex"
+  "math/big"
+  "testing"
+)
+
+var testTxKey, _ = hex.DecodeString("3ecb44df2159c26e0f995712d4f39b6f6e499b40749b1cf1246c37f9516cb6a4")
+
+func TestTransactionSignVerify(t *testing.T) {
+  tx := NewTransaction("recipient", 100, []string{"PUSH 1"})
+  if err := tx.Sign(testTxKey); err != nil {
+    t.Fatal(err)
+  }
+
+  if len(tx.signature) != 65 {
+    t.Errorf("Expected 65 byte signature, got %d", len(tx.signature))
+  }
+  if len(tx.sender) != 20 {
+    t.Errorf("Expected 20 byte sender, got %x", tx.sender)
+  }
+  if !tx.Verify() {
+    t.Error("Expected signed transaction to verify")
+  }
+
+  // The signature is the last element of the signed encoding only
+  if bytes.Compare(tx.SigHash(), Hash(tx.MarshalUnsignedRlp())) != 0 {
+    t.Error("Expected the hash to exclude the signature")
+  }
+  dec := &Transaction{}
+  dec.UnmarshalRlp(tx.MarshalRlp())
+  if bytes.Compare(dec.signature, tx.signature) != 0 || dec.sender != tx.sender {
+    t.Error("Expected decoded transaction to carry the signature and sender")
+  }
+}
+
+func TestTransactionTampered(t *testing.T) {
+  tx := NewTransaction("recipient", 100, nil)
+  tx.Sign(testTxKey)
+
+  tx.value = 1000
+  if tx.Verify() {
+    t.Error("Expected tampered transaction not to verify")
+  }
+}
+
+func TestTransactionTamperedEncoding(t *testing.T) {
+  tx := NewTransaction("recipient", 100, []string{"PUSH 1"})
+  tx.Sign(testTxKey)
+
+  tampered := []struct{ name, from, to string }{
+    {"recipient", "recipient", "recipienu"},
+    {"sender", tx.sender, string(bytes.Repeat([]byte{1}, 20))},
+  }
+  for _, c := range tampered {
+    data := bytes.Replace(tx.MarshalRlp(), []byte(c.from), []byte(c.to), 1)
+    if bytes.Compare(data, tx.MarshalRlp()) == 0 {
+      t.Fatalf("Expected %s to be tampered with", c.name)
+    }
+
+    dec := &Transaction{}
+    dec.UnmarshalRlp(data)
+    if dec.Verify() {
+      t.Errorf("Expected transaction with tampered %s not to verify", c.name)
+    }
+  }
+
+  // The untouched encoding still verifies
+  dec := &Transaction{}
+  dec.UnmarshalRlp(tx.MarshalRlp())
+  if !dec.Verify() {
+    t.Error("Expected decoded transaction to verify")
+  }
+}
+
+func TestTransactionUnsigned(t *testing.T) {
+  tx := NewTransaction("recipient", 100, nil)
+  if tx.Verify() {
+    t.Error("Expected unsigned transaction not to verify")
+  }
+  if tx.Sender() != nil {
+    t.Error("Expected unsigned transaction not to have a sender")
+  }
+
+  tx.signature = []byte{}
+  if tx.Verify() {
+    t.Error("Expected empty signature not to verify")
+  }
+}
+
+func TestTransactionHash(t *testing.T) {
+  tx := NewTransaction("recipient", 100, nil)
+  tx.Sign(testTxKey)
+
+  // The canonical hash covers the signature
+  if bytes.Compare(tx.Hash(), Hash(tx.MarshalRlp())) != 0 {
+    t.Error("Expected the hash to be the hash of the signed transaction")
+  }
+  unsigned := NewTransaction("recipient", 100, nil)
+  if bytes.Compare(tx.Hash(), unsigned.Hash()) == 0 {
+    t.Error("Expected the signature to be part of the hash")
+  }
+
+  // The block's transaction root is computed from the very same hashes
+  tx2 := NewTransaction("other", 200, nil)
+  tx2.Sign(testTxKey)
+
+  root := Hash(Encode([]string{string(tx.Hash()), string(tx2.Hash())}))
+  if bytes.Compare(TxRoot([]*Transaction{tx, tx2}), root) != 0 {
+    t.Error("Expected the transaction root to be derived from tx.Hash")
+  }
+
+  block := CreateTestBlock([]*Transaction{tx, tx2})
+  block.state = NewTrie(Db, "")
+  header := NewRlpDecoder(block.MarshalRlp()).Get(0)
+  if bytes.Compare(header.Get(5).AsBytes(), root) != 0 {
+    t.Errorf("Expected block's transaction root %x, got %x", root, header.Get(5).AsBytes())
+  }
+}
+
+func TestTransactionFee(t *testing.T) {
+  // Distinct bases so using the wrong one shows. The package wide fees are
+  // restored afterwards.
+  txFee, contractFee, dataFee := new(big.Int).Set(TxFee), new(big.Int).Set(ContractFee), new(big.Int).Set(DataFee)
+  defer func() {
+    TxFee.Set(txFee)
+    ContractFee.Set(contractFee)
+    DataFee.Set(dataFee)
+  }()
+  TxFee.SetInt64(100)
+  ContractFee.SetInt64(1000)
+  DataFee.SetInt64(10)
+
+  tx := NewTransaction("recipient", 100, nil)
+  if tx.fee.Cmp(big.NewInt(100)) != 0 {
+    t.Errorf("Expected fee 100, got %v", tx.fee)
+  }
+
+  var data []string
+  for i := 0; i < 10; i++ {
+    data = append(data, "PUSH 1")
+  }
+  tx = NewTransaction("recipient", 100, data)
+  if tx.fee.Cmp(big.NewInt(200)) != 0 {
+    t.Errorf("Expected fee 200, got %v", tx.fee)
+  }
+
+  tx = NewTransaction(string(make([]byte, 20)), 100, []string{"PUSH 1"})
+  if tx.fee.Cmp(big.NewInt(1010)) != 0 {
+    t.Errorf("Expected contract fee 1010, got %v", tx.fee)
+  }
+
+  // The fee survives encoding
+  dec := &Transaction{}
+  dec.UnmarshalRlp(tx.MarshalRlp())
+  if dec.fee.Cmp(big.NewInt(1010)) != 0 {
+    t.Errorf("Expected decoded fee 1010, got %v", dec.fee)
+  }
+}
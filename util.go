@@ -25,6 +25,12 @@ func Sha256Bin(data []byte) []byte {
   return hash[:]
 }
 
+// The canonical hash function. Transactions, blocks and the roots derived
+// from them are all hashed with it.
+func Hash(data []byte) []byte {
+  return Sha256Bin(data)
+}
+
 func Sha3Bin(data []byte) []byte {
   d := sha3.NewKeccak224()
   d.Reset()